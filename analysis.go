@@ -11,6 +11,7 @@ type PolicyAnalysis struct {
 	PolicyViolated bool
 
 	TaintingAPIName string
+	SinkViolations  []SinkViolation
 
 	ReqResourceType string
 	ReqURL          string
@@ -45,8 +46,89 @@ func (cc *ChromeClient) AnalyzePolicy(pid string) PolicyAnalysis {
 	}
 	pa.Description = presendLogs[0].DocumentURL
 
+	// A script whose fetched body doesn't match its declared integrity
+	// attribute is a violation on its own: it means the page trusted an
+	// exact vendored script that something has since tampered with, which
+	// the usual trust-group taint tracking below can't express.
+	if mismatches := cc.IntegrityMismatchLogs(pid); len(mismatches) > 0 {
+		pa.PolicyViolated = true
+		pa.TaintingAPIName = "integrity_mismatch"
+		pa.ReqResourceType = "Script"
+		pa.ReqURL = mismatches[0].URL
+		return pa
+	}
+
+	// A script-submitted form from a frame whose sandbox="..." attribute
+	// lacks allow-forms is a violation independent of taint state: the page
+	// author explicitly said this frame may not submit forms, so it
+	// doesn't matter whether the script that tried is trusted. An ordinary
+	// script-set navigation (location.href = ..., a target=_top link) is
+	// not a form submission at all and is gated by allow-top-navigation
+	// instead, checked below.
+	for _, e := range presendLogs {
+		if e.ResourceType != "Document" || e.Initiator == nil || e.Initiator.Type != "script" || !isFormSubmission(e.Request) {
+			continue
+		}
+		if cc.frames[e.FrameId].Allows(cc.frames, "allow-forms") {
+			continue
+		}
+		pa.PolicyViolated = true
+		pa.TaintingAPIName = "sandboxed_form_submission"
+		pa.ReqResourceType = e.ResourceType
+		pa.ReqURL = e.Request.URL
+		pa.ReqInitiator = e.Initiator.Type
+		return pa
+	}
+
+	// A script-initiated top-level navigation (location.href = ...,
+	// target=_top, ...) from a frame whose sandbox="..." attribute lacks
+	// allow-top-navigation is likewise a violation independent of taint
+	// state. FrameId here is the frame CDP reports the navigation
+	// happening in, which for a script assigning to its own
+	// window.location is that frame itself; this is exact for that case
+	// but, like the window.open check below, can't attribute a navigation
+	// to an ancestor's script to the ancestor rather than the frame CDP
+	// reports it against, since nothing maps a script to the frame it ran
+	// in (see FrameNode in frames.go).
+	for _, e := range presendLogs {
+		if e.ResourceType != "Document" || e.Initiator == nil || e.Initiator.Type != "script" || isFormSubmission(e.Request) {
+			continue
+		}
+		if cc.frames[e.FrameId].Allows(cc.frames, "allow-top-navigation") {
+			continue
+		}
+		pa.PolicyViolated = true
+		pa.TaintingAPIName = "sandboxed_top_navigation"
+		pa.ReqResourceType = e.ResourceType
+		pa.ReqURL = e.Request.URL
+		pa.ReqInitiator = e.Initiator.Type
+		return pa
+	}
+
+	// A window.open() whose opener target has a sandboxed frame lacking
+	// allow-popups is likewise flagged independent of taint state. CDP only
+	// attributes a popup's opener at Target granularity (Target.openerId),
+	// not to the specific frame within that target that called
+	// window.open, so this is precise for a single sandboxed frame per
+	// opener target (as in the TestSandboxedPopup fixtures in test_handlers.go) but can
+	// over-flag if multiple frames in the same opener target differ in
+	// their allow-popups token.
+	if opener, ok := cc.targetOpeners[presendLogs[0].TargetId]; ok {
+		for _, f := range cc.frames {
+			if f.TargetId == opener && !f.Allows(cc.frames, "allow-popups") {
+				pa.PolicyViolated = true
+				pa.TaintingAPIName = "sandboxed_window_open"
+				pa.ReqResourceType = presendLogs[0].ResourceType
+				pa.ReqURL = presendLogs[0].Request.URL
+				return pa
+			}
+		}
+	}
+
 	// Check if the untrusted trust group was tainted.
 	apiLogs := cc.APIAccessLogs(pid)
+	pa.SinkViolations = findSinkViolations(apiLogs)
+
 	var taintingEvent *APIAccessEvent
 	for _, e := range apiLogs {
 		if strings.HasPrefix(e.APIName, "exfiltration_") {
@@ -105,6 +187,33 @@ func (cc *ChromeClient) AnalyzePolicy(pid string) PolicyAnalysis {
 		}
 	}
 
+	// Check for potential exfiltration over WebSocket frame sends, the same
+	// way as the NetworkRequestWillBeSentLogs check above: a frame sent by
+	// an untrusted script after taintingEvent is exfiltration even though
+	// it never shows up as a Network.requestWillBeSent (a WebSocket's
+	// handshake request does, but the data frames sent over it afterward
+	// don't).
+	for _, e := range cc.WebSocketFrameSentLogs(pid) {
+		if e.EventId <= taintingEvent.EventId || (pa.PolicyViolated && e.EventId >= exfiltrationEventId) {
+			continue
+		}
+
+		scriptIdStack := scriptIdStackOf(e.Initiator)
+		if e.PolicyState.StackIsTrusted(scriptIdStack) {
+			continue
+		}
+
+		exfiltrationEventId = e.EventId
+		pa.PolicyViolated = true
+		pa.ReqResourceType = "WebSocket"
+		if e.Initiator != nil {
+			pa.ReqInitiator = e.Initiator.Type
+		}
+		pa.ReqURL = e.URL
+		pa.ReqStackScripts = scriptIdStack
+		break
+	}
+
 	// Check for potential earlier exfiltration in APIAccessLogs.
 	for _, e := range apiLogs {
 		if !strings.HasPrefix(e.APIName, "exfiltration_") {
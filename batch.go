@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchPortStride spaces each concurrent worker's remote-debugging and web
+// server ports far enough apart that their ephemeral ranges can't collide.
+const batchPortStride = 100
+
+// RunSummary is one line of the batch run's aggregate runs.jsonl, and the
+// input to the summary.json/summary.html report built by writeBatchSummary.
+type RunSummary struct {
+	URL             string
+	PolicyId        string
+	BlockedCount    int
+	ExfilCandidates int
+	ScreenshotPath  string
+
+	PolicyViolated    bool
+	ViolatedDirective string
+	TaintingAPIName   string
+	TaintChain        []string
+	BlockedHostnames  []string
+
+	// Error is set instead of the fields above when the URL never
+	// finished: the worker's Chromium process crashed or hit -url-timeout
+	// partway through it. See runBatchWorker.
+	Error string `json:",omitempty"`
+}
+
+// readBatchURLs reads urlListPath (or stdin, if "-") in -url-list-format.
+func readBatchURLs(urlListPath string) []string {
+	in := os.Stdin
+	if urlListPath != "-" {
+		f, err := os.Open(urlListPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	switch urlListFormat {
+	case "csv":
+		return readBatchURLsCSV(in)
+	case "jsonl":
+		return readBatchURLsJSONL(in)
+	default:
+		return readBatchURLsPlain(in)
+	}
+}
+
+func readBatchURLsPlain(in io.Reader) []string {
+	urls := []string{}
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		u := strings.TrimSpace(s.Text())
+		if u == "" {
+			continue
+		}
+		urls = append(urls, u)
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return urls
+}
+
+// readBatchURLsCSV takes the first column of each row as the URL, so a
+// corpus that also carries metadata (rank, category, ...) per site can be
+// fed in directly instead of pre-extracting a plain list.
+func readBatchURLsCSV(in io.Reader) []string {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+
+	urls := []string{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(record) == 0 {
+			continue
+		}
+		if u := strings.TrimSpace(record[0]); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// batchURLListEntry is one line of a -url-list-format=jsonl file.
+type batchURLListEntry struct {
+	URL string `json:"url"`
+}
+
+func readBatchURLsJSONL(in io.Reader) []string {
+	urls := []string{}
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		var entry batchURLListEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			log.Fatal(err)
+		}
+		if entry.URL != "" {
+			urls = append(urls, entry.URL)
+		}
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return urls
+}
+
+func urlRunDir(baseDir string, targetURL string) string {
+	h := sha1.New()
+	io.WriteString(h, targetURL)
+	return path.Join(baseDir, hex.EncodeToString(h.Sum(nil)))
+}
+
+// runBatch crawls every URL in urlListPath (or stdin, if "-") across up to
+// concurrency isolated Chromium processes, each cycling through its share
+// of the queue on a single reused tab (respawning on a crash or
+// -url-timeout; see runBatchWorker), and writes a runs.jsonl summary (one
+// RunSummary line per URL) plus an aggregate summary.json/summary.html
+// report to runDir.
+func runBatch(urlListPath string, concurrency int) {
+	urls := readBatchURLs(urlListPath)
+
+	shares := make([][]string, concurrency)
+	for i, u := range urls {
+		w := i % concurrency
+		shares[w] = append(shares[w], u)
+	}
+
+	var summariesMu sync.Mutex
+	var allSummaries []RunSummary
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		if len(shares[worker]) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(worker int, urls []string) {
+			defer wg.Done()
+
+			summaries := runBatchWorker(worker, urls)
+
+			summariesMu.Lock()
+			allSummaries = append(allSummaries, summaries...)
+			summariesMu.Unlock()
+		}(worker, shares[worker])
+	}
+	wg.Wait()
+
+	f, err := os.Create(path.Join(runDir, "runs.jsonl"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, s := range allSummaries {
+		b, err := json.Marshal(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f.Write(b)
+		f.WriteString("\n")
+	}
+
+	writeBatchSummary(runDir, allSummaries)
+	uploadBatchSummary(runDir)
+}
+
+// uploadBatchSummary ships the batch-wide runs.jsonl/summary.json/
+// summary.html report to resultsSink. Per-URL results are already
+// uploaded as each one finishes (see finishURL), so these three files —
+// which only exist once every worker has finished — are all that's left
+// to send; tarring the whole batch runDir here would just re-upload
+// everything finishURL already shipped.
+func uploadBatchSummary(runDir string) {
+	if resultsSink == nil {
+		return
+	}
+	for _, name := range []string{"runs.jsonl", "summary.json", "summary.html"} {
+		local := path.Join(runDir, name)
+		if err := resultsSink.UploadFile(local, path.Join("batch", name)); err != nil {
+			log.Println("Batch summary upload failed for", local, ":", err)
+		}
+	}
+}
+
+// runBatchWorker drives urls through a ChromeClient. If the Chromium
+// process crashes or a URL exceeds -url-timeout, the URL in flight is
+// recorded as a failure and a fresh ChromeClient (its own run subdir,
+// profile, and attempt-scoped ports reuse) picks up whatever of the share
+// is still queued, so one bad URL doesn't sink the rest of the worker's
+// share.
+func runBatchWorker(worker int, urls []string) []RunSummary {
+	var summaries []RunSummary
+	remaining := urls
+
+	for attempt := 0; len(remaining) > 0; attempt++ {
+		cc, failedURL, err := runBatchWorkerAttempt(worker, attempt, remaining)
+		summaries = append(summaries, cc.runSummaries...)
+		remaining = cc.urlQueue
+
+		if failedURL != "" {
+			log.Printf("Worker %v: %v did not finish (%v), moving on", worker, failedURL, err)
+			summaries = append(summaries, RunSummary{URL: failedURL, Error: err.Error()})
+		}
+	}
+
+	return summaries
+}
+
+// runBatchWorkerAttempt runs one ChromeClient lifetime against urls.
+// cc.runSummaries holds every URL it completed; cc.urlQueue holds
+// whatever was left when it stopped. failedURL is non-empty only if the
+// process stopped with an error (crash or -url-timeout) while it was in
+// the middle of that URL.
+func runBatchWorkerAttempt(worker int, attempt int, urls []string) (cc *ChromeClient, failedURL string, err error) {
+	rd := path.Join(runDir, "worker", strconv.Itoa(worker), "attempt-"+strconv.Itoa(attempt))
+	os.MkdirAll(rd, os.ModePerm)
+
+	cc = NewChromeClient(rd, policyType, remoteDebuggingPort+worker*batchPortStride, webServerPort+worker*batchPortStride)
+	cc.batchBaseDir = runDir
+	cc.urlQueue = urls
+
+	cc.WaitReady()
+
+	first, ok := cc.popNextURL()
+	if !ok {
+		cc.Shutdown(nil)
+		cc.WaitStopped()
+		return cc, "", nil
+	}
+
+	cc.beginURL(first)
+	cc.OpenURL(first)
+
+	if stopErr := cc.WaitStopped(); stopErr != nil {
+		cc.Lock()
+		failedURL = cc.currentURL
+		cc.Unlock()
+		return cc, failedURL, stopErr
+	}
+
+	return cc, "", nil
+}
+
+func (cc *ChromeClient) popNextURL() (string, bool) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	if len(cc.urlQueue) == 0 {
+		return "", false
+	}
+	u := cc.urlQueue[0]
+	cc.urlQueue = cc.urlQueue[1:]
+	return u, true
+}
+
+// beginURL points the ChromeClient's run/log directories and per-URL event
+// correlation state at targetURL, so events logged from here on are
+// attributed only to it. If -url-timeout is set, it also arms urlTimer to
+// abandon targetURL (by crashing the process, the same as Chromium dying
+// on its own) if it hasn't finished loading in time.
+func (cc *ChromeClient) beginURL(targetURL string) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	rd := urlRunDir(cc.batchBaseDir, targetURL)
+	os.MkdirAll(path.Join(rd, "logs"), os.ModePerm)
+	logsDir, err := filepath.Abs(path.Join(rd, "logs"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cc.runDir = rd
+	cc.logsDir = logsDir
+	cc.currentURL = targetURL
+	cc.eventLog = nil
+	cc.nextEventId = 0
+	cc.policyIds = nil
+	cc.targets = make(map[string]*Target)
+	cc.watchedTargets = nil
+	cc.frames = make(map[string]*FrameNode)
+	cc.targetOpeners = make(map[string]string)
+
+	if urlTimeout > 0 {
+		cc.urlTimer = time.AfterFunc(urlTimeout, func() {
+			cc.Shutdown(fmt.Errorf("timed out waiting for %v", targetURL))
+		})
+	}
+}
+
+// cancelURLTimeout disarms the timer beginURL set for the URL that just
+// finished, so it doesn't fire after the worker has already moved on.
+func (cc *ChromeClient) cancelURLTimeout() {
+	cc.Lock()
+	timer := cc.urlTimer
+	cc.urlTimer = nil
+	cc.Unlock()
+
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// finishURL flushes the just-completed URL's logs and a run summary, and in
+// batch mode uploads them to resultsSink under this URL's own run dir (so a
+// worker killed mid-batch has already durably shipped every URL it
+// finished), then either cycles the same tab to the next queued URL or
+// shuts the process down once the queue is drained. It also runs for a
+// plain, non-batch -open-url run (see pageLifecycleEvent), where the
+// flush/upload is skipped: runURL already does both, once, after
+// WaitStopped returns.
+func (cc *ChromeClient) finishURL(t *Target) {
+	cc.cancelURLTimeout()
+	if batchMode {
+		cc.WriteLogToFile()
+		if harOut {
+			cc.WriteHARToFile()
+		}
+		if policy := loadConfiguredCSPPolicy(); policy != nil {
+			if cc.WriteCSPReportsToFile(policy, cspReportOnly) && !cspReportOnly {
+				log.Println("CSP policy violated for", cc.currentURL)
+			}
+		}
+		cc.recordRunSummary(t)
+		uploadRunResults(cc.runDir, path.Join("batch", path.Base(cc.runDir)))
+	}
+
+	nextURL, ok := cc.popNextURL()
+	if !ok {
+		cc.Shutdown(nil)
+		return
+	}
+
+	cc.beginURL(nextURL)
+
+	if err := t.NavigateTo(nextURL); err != nil {
+		log.Println("Navigate error:", err)
+		cc.Shutdown(err)
+		return
+	}
+
+	cc.Lock()
+	cc.watchedTargets = append(cc.watchedTargets, t.TargetId)
+	cc.targets[t.TargetId] = t
+	cc.targetStartTimes[t.TargetId] = time.Now()
+	cc.Unlock()
+}
+
+// recordRunSummary appends cc.currentURL's RunSummary, including the
+// PolicyAnalysis fields the batch summary report groups on: the directive
+// violated (if any), the tainting API, its taint chain, and the hostnames
+// of any requests blocked for the URL's policy.
+func (cc *ChromeClient) recordRunSummary(t *Target) {
+	policyId := t.Policy.Id
+	pa := cc.AnalyzePolicy(policyId)
+
+	cc.Lock()
+	defer cc.Unlock()
+
+	blocked := cc.NetworkRequestBlockedLogs(policyId)
+	hostnames := []string{}
+	for _, b := range blocked {
+		if u, err := url.Parse(b.URL); err == nil && u.Hostname() != "" {
+			hostnames = append(hostnames, u.Hostname())
+		}
+	}
+
+	violatedDirective := ""
+	if pa.PolicyViolated {
+		if pa.ReqResourceType != "" {
+			violatedDirective = directiveForResourceType(pa.ReqResourceType)
+		} else {
+			violatedDirective = directiveForAPIName(pa.TaintingAPIName)
+		}
+	}
+
+	cc.runSummaries = append(cc.runSummaries, RunSummary{
+		URL:             cc.currentURL,
+		PolicyId:        policyId,
+		BlockedCount:    len(blocked),
+		ExfilCandidates: len(cc.APIAccessLogs(policyId)),
+		ScreenshotPath:  path.Join(cc.runDir, "screenshot.png"),
+
+		PolicyViolated:    pa.PolicyViolated,
+		ViolatedDirective: violatedDirective,
+		TaintingAPIName:   pa.TaintingAPIName,
+		TaintChain:        pa.ReqStackScripts,
+		BlockedHostnames:  hostnames,
+	})
+}
+
+// BatchSummary is the aggregate report written to summary.json/
+// summary.html: policy violations grouped by the CSP directive violated,
+// the third-party hostnames blocked most often across the batch, and each
+// violating URL's taint chain, for reviewing a batch run at a glance
+// instead of URL by URL.
+type BatchSummary struct {
+	TotalURLs     int
+	ViolatedCount int
+	FailedCount   int
+	ByDirective   map[string]int
+	TopHostnames  []HostnameCount
+	TaintedURLs   []URLTaintChain
+}
+
+// HostnameCount is one row of BatchSummary.TopHostnames.
+type HostnameCount struct {
+	Hostname string
+	Count    int
+}
+
+// URLTaintChain is one row of BatchSummary.TaintedURLs.
+type URLTaintChain struct {
+	URL               string
+	ViolatedDirective string
+	TaintingAPIName   string
+	TaintChain        []string
+}
+
+// maxTopHostnames caps BatchSummary.TopHostnames so a batch with a long
+// tail of one-off third parties still produces a report worth skimming.
+const maxTopHostnames = 20
+
+func buildBatchSummary(summaries []RunSummary) BatchSummary {
+	bs := BatchSummary{
+		TotalURLs:   len(summaries),
+		ByDirective: map[string]int{},
+	}
+
+	hostnameCounts := map[string]int{}
+	for _, s := range summaries {
+		if s.Error != "" {
+			bs.FailedCount++
+			continue
+		}
+		for _, h := range s.BlockedHostnames {
+			hostnameCounts[h]++
+		}
+		if !s.PolicyViolated {
+			continue
+		}
+
+		bs.ViolatedCount++
+		bs.ByDirective[s.ViolatedDirective]++
+		bs.TaintedURLs = append(bs.TaintedURLs, URLTaintChain{
+			URL:               s.URL,
+			ViolatedDirective: s.ViolatedDirective,
+			TaintingAPIName:   s.TaintingAPIName,
+			TaintChain:        s.TaintChain,
+		})
+	}
+
+	for h, c := range hostnameCounts {
+		bs.TopHostnames = append(bs.TopHostnames, HostnameCount{Hostname: h, Count: c})
+	}
+	sort.Slice(bs.TopHostnames, func(i, j int) bool {
+		return bs.TopHostnames[i].Count > bs.TopHostnames[j].Count
+	})
+	if len(bs.TopHostnames) > maxTopHostnames {
+		bs.TopHostnames = bs.TopHostnames[:maxTopHostnames]
+	}
+
+	return bs
+}
+
+var batchSummaryTemplate = template.Must(template.New("summary").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<title>Batch run summary</title>
+</head>
+<body>
+	<h1>Batch run summary</h1>
+	<p>{{.TotalURLs}} URLs, {{.ViolatedCount}} policy violations, {{.FailedCount}} crashed or timed out.</p>
+
+	<h2>Violations by directive</h2>
+	<table border="1" cellpadding="4">
+		<tr><th>Directive</th><th>Count</th></tr>
+		{{range $directive, $count := .ByDirective}}
+		<tr><td>{{$directive}}</td><td>{{$count}}</td></tr>
+		{{end}}
+	</table>
+
+	<h2>Top blocked hostnames</h2>
+	<table border="1" cellpadding="4">
+		<tr><th>Hostname</th><th>Count</th></tr>
+		{{range .TopHostnames}}
+		<tr><td>{{.Hostname}}</td><td>{{.Count}}</td></tr>
+		{{end}}
+	</table>
+
+	<h2>Per-URL taint chains</h2>
+	<table border="1" cellpadding="4">
+		<tr><th>URL</th><th>Directive</th><th>Tainting API</th><th>Stack</th></tr>
+		{{range .TaintedURLs}}
+		<tr><td>{{.URL}}</td><td>{{.ViolatedDirective}}</td><td>{{.TaintingAPIName}}</td><td>{{range .TaintChain}}{{.}} {{end}}</td></tr>
+		{{end}}
+	</table>
+</body>
+</html>
+`))
+
+// writeBatchSummary aggregates summaries with buildBatchSummary and writes
+// the result as both summary.json (for programmatic consumption) and
+// summary.html (for a human skimming the run) to runDir.
+func writeBatchSummary(runDir string, summaries []RunSummary) {
+	bs := buildBatchSummary(summaries)
+
+	b, err := json.MarshalIndent(bs, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(runDir, "summary.json"), b, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Create(path.Join(runDir, "summary.html"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := batchSummaryTemplate.Execute(f, bs); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -19,11 +19,14 @@ import (
 	"time"
 
 	"github.com/ethanal/godet"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type ChromeClient struct {
 	sync.RWMutex
 	runDir                string
+	remoteDebuggingPort   int
+	webServerPort         int
 	ready                 chan struct{}
 	attached              chan struct{}
 	shutdown              chan error
@@ -38,19 +41,46 @@ type ChromeClient struct {
 	instrumentationScript string
 	watchedTargets        []string
 	logsDir               string
+	policyStore           *PolicyStore
+	subscribers           []*eventSubscriber
+	registry              *prometheus.Registry
+	metrics               *metrics
+	targetStartTimes      map[string]time.Time
+	frames                map[string]*FrameNode
+	targetOpeners         map[string]string
+
+	// Batch-mode state: urlQueue holds the URLs still to crawl in this
+	// process, batchBaseDir is the worker's top-level run dir that each
+	// URL's own <sha1(url)>/ subdir is computed relative to, and
+	// runSummaries accumulates one entry per completed URL. urlTimer
+	// enforces -url-timeout against currentURL; see beginURL/finishURL.
+	urlQueue     []string
+	batchBaseDir string
+	currentURL   string
+	urlTimer     *time.Timer
+	runSummaries []RunSummary
 }
 
-func NewChromeClient(runDir string, policyType int) *ChromeClient {
+func NewChromeClient(runDir string, policyType int, remoteDebuggingPort int, webServerPort int) *ChromeClient {
 	cc := &ChromeClient{
-		runDir:     runDir,
-		policyType: policyType,
-		ready:      make(chan struct{}),
-		attached:   make(chan struct{}),
-		shutdown:   make(chan error),
-		stopped:    make(chan error),
-		targets:    make(map[string]*Target),
+		runDir:              runDir,
+		policyType:          policyType,
+		remoteDebuggingPort: remoteDebuggingPort,
+		webServerPort:       webServerPort,
+		ready:               make(chan struct{}),
+		attached:            make(chan struct{}),
+		shutdown:            make(chan error),
+		stopped:             make(chan error),
+		targets:             make(map[string]*Target),
+		targetStartTimes:    make(map[string]time.Time),
+		frames:              make(map[string]*FrameNode),
+		targetOpeners:       make(map[string]string),
 	}
 
+	cc.policyStore = NewPolicyStore(policyStorePath)
+	cc.registry = prometheus.NewRegistry()
+	cc.metrics = newMetrics(cc.registry)
+
 	cc.initInstrumentationScript()
 	srv := cc.initTestServer()
 
@@ -169,7 +199,7 @@ func NewChromeClient(runDir string, policyType int) *ChromeClient {
 }
 
 func (cc *ChromeClient) WebServerURL(path string) string {
-	return fmt.Sprintf("http://localhost:%v%v", webServerPort, path)
+	return fmt.Sprintf("http://localhost:%v%v", cc.webServerPort, path)
 }
 
 func (cc *ChromeClient) WaitAttached() {
@@ -272,8 +302,15 @@ func (cc *ChromeClient) targetCreated(p Message) {
 	}
 
 	tid := p.Message("targetInfo").String("targetId")
+	if openerId := p.Message("targetInfo").String("openerId"); openerId != "" {
+		cc.Lock()
+		cc.targetOpeners[tid] = openerId
+		cc.Unlock()
+	}
+
 	if _, ok := cc.targets[tid]; !ok {
 		cc.targets[tid] = NewTarget(cc, tid, p.Message("targetInfo").String("url"))
+		cc.metrics.activeTargets.Set(float64(len(cc.targets)))
 	}
 }
 
@@ -291,7 +328,7 @@ func (cc *ChromeClient) setupRootConn() {
 	}
 
 	for {
-		cc.rootConn, err = godet.Connect(fmt.Sprintf("localhost:%v", remoteDebuggingPort), verbose)
+		cc.rootConn, err = godet.Connect(fmt.Sprintf("localhost:%v", cc.remoteDebuggingPort), verbose)
 		if err == nil {
 			break
 		}
@@ -328,7 +365,9 @@ func (cc *ChromeClient) OpenURL(url string) error {
 	if err != nil {
 		return err
 	}
-	cc.watchedTargets = append(cc.watchedTargets, Message(r).String("targetId"))
+	tid := Message(r).String("targetId")
+	cc.watchedTargets = append(cc.watchedTargets, tid)
+	cc.targetStartTimes[tid] = time.Now()
 
 	return nil
 }
@@ -336,7 +375,21 @@ func (cc *ChromeClient) OpenURL(url string) error {
 func (cc *ChromeClient) pageLifecycleEvent(p Message) {
 
 	tid := p.String("frameId")
-	if p.String("name") == "load" {
+	name := p.String("name")
+
+	if name == "networkIdle" {
+		for _, wt := range cc.watchedTargets {
+			if wt == tid {
+				t := cc.targets[tid]
+				if t.Policy.LearnedOrigin != "" {
+					cc.policyStore.Commit(t.Policy.LearnedOrigin, t.Policy.State())
+				}
+				return
+			}
+		}
+	}
+
+	if name == "load" {
 		for _, wt := range cc.watchedTargets {
 			if wt == tid {
 				t := cc.targets[tid]
@@ -351,8 +404,11 @@ func (cc *ChromeClient) pageLifecycleEvent(p Message) {
 					Name:        p.String("name"),
 					PolicyState: t.Policy.State(),
 				})
+				if start, ok := cc.targetStartTimes[tid]; ok {
+					cc.metrics.pageLoadSeconds.WithLabelValues(t.Policy.Id).Observe(time.Since(start).Seconds())
+				}
 				cc.pageLoaded(tid)
-				cc.Shutdown(nil)
+				cc.finishURL(t)
 				return
 			}
 		}
@@ -379,6 +435,8 @@ func (cc *ChromeClient) pageLoaded(tid string) {
 	if err != nil {
 		log.Fatal("Error writing screenshot to file:", err)
 	}
+
+	target.checkSubresourceIntegrity()
 }
 
 func pipeToLog(rd io.Reader) {
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
+
+	"github.com/eal5ub/web-exfiltration/controlpb"
+)
+
+// run is the control-plane's view of one StartRun call.
+type run struct {
+	id           string
+	chromeClient *ChromeClient
+}
+
+// controlServer implements controlpb.ExfilRunnerServer, wrapping the
+// existing ChromeClient/runURL machinery (main.go) in a registry keyed by
+// run_id so an external orchestrator can drive runs over gRPC instead of
+// scraping the HTML test server. Each StartRun mirrors the batch-mode
+// refactor in batch.go: its own runDir, remoteDebuggingPort and
+// webServerPort (striped by batchPortStride) and its own Chromium
+// subprocess.
+type controlServer struct {
+	controlpb.UnimplementedExfilRunnerServer
+
+	mu      sync.Mutex
+	runs    map[string]*run
+	nextIdx int32
+}
+
+func newControlServer() *controlServer {
+	return &controlServer{
+		runs: make(map[string]*run),
+	}
+}
+
+// runControlServer serves the controlpb.ExfilRunner gRPC service on
+// -grpc-addr. -control mode has no single HTTP server of its own the way a
+// plain -open-url run does (each StartRun gets its own test server on its
+// own ports, same as a batch worker), so a small JSON index of active runs
+// is multiplexed onto the same listener via cmux for anyone who'd rather
+// curl it than write a gRPC client.
+func runControlServer() {
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	m := cmux.New(lis)
+	grpcL := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc"))
+	httpL := m.Match(cmux.Any())
+
+	cs := newControlServer()
+
+	grpcServer := grpc.NewServer(grpc.ForceServerCodec(controlpb.Codec))
+	controlpb.RegisterExfilRunnerServer(grpcServer, cs)
+	go grpcServer.Serve(grpcL)
+
+	httpServer := &http.Server{Handler: http.HandlerFunc(cs.indexHandler)}
+	go httpServer.Serve(httpL)
+
+	log.Println("Control plane listening on", grpcAddr)
+	if err := m.Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *controlServer) indexHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.runs))
+	for id := range s.runs {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	b, err := json.MarshalIndent(map[string]interface{}{"runs": ids}, "", "  ")
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Write(b)
+}
+
+func (s *controlServer) getRun(runId string) (*run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[runId]
+	if !ok {
+		return nil, fmt.Errorf("no such run: %v", runId)
+	}
+	return r, nil
+}
+
+func (s *controlServer) StartRun(ctx context.Context, req *controlpb.StartRunRequest) (*controlpb.RunHandle, error) {
+	idx := int(atomic.AddInt32(&s.nextIdx, 1) - 1)
+	runId := fmt.Sprintf("run-%d", idx)
+	rd := path.Join(runDir, "control", runId)
+
+	if req.Options != nil {
+		applyRunOptions(req.Options)
+	}
+
+	cc := NewChromeClient(rd, int(req.Policy), remoteDebuggingPort+idx*batchPortStride, webServerPort+idx*batchPortStride)
+
+	s.mu.Lock()
+	s.runs[runId] = &run{id: runId, chromeClient: cc}
+	s.mu.Unlock()
+
+	cc.WaitReady()
+
+	if req.URL != "" {
+		if err := cc.OpenURL(req.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	go s.finalizeRun(runId, cc)
+
+	return &controlpb.RunHandle{RunId: runId}, nil
+}
+
+// applyRunOptions overwrites the process-wide flags NewChromeClient and its
+// Targets read at run time (headless, enforceMode, harOut, hostnameMatchMode
+// — see main.go and policy.go): they're global rather than per-ChromeClient
+// state, so this is only safe for one StartRun in flight at a time with
+// non-default options.
+func applyRunOptions(opts *controlpb.RunOptions) {
+	headless = opts.Headless
+	enforceMode = opts.Enforce
+	harOut = opts.HarOut
+
+	switch opts.HostnameMatch {
+	case "subdomain":
+		hostnameMatchMode = hostnameMatchSubdomain
+	case "etld+1":
+		hostnameMatchMode = hostnameMatchETLDPlusOne
+	default:
+		hostnameMatchMode = hostnameMatchExact
+	}
+}
+
+// finalizeRun waits for run's Chromium process to stop and writes its logs,
+// mirroring runURL in main.go, but leaves the run in the registry so
+// GetArtifact can keep serving its results afterwards.
+func (s *controlServer) finalizeRun(runId string, cc *ChromeClient) {
+	if err := cc.WaitStopped(); err != nil {
+		log.Println("Run", runId, "stopped with error:", err)
+	}
+
+	cc.WriteLogToFile()
+	if harOut {
+		cc.WriteHARToFile()
+	}
+	if policy := loadConfiguredCSPPolicy(); policy != nil {
+		if cc.WriteCSPReportsToFile(policy, cspReportOnly) && !cspReportOnly {
+			log.Println("Run", runId, "violated CSP policy")
+		}
+	}
+
+	uploadRunResults(cc.runDir, runId)
+}
+
+func (s *controlServer) StreamEvents(req *controlpb.StreamEventsRequest, stream controlpb.ExfilRunner_StreamEventsServer) error {
+	r, err := s.getRun(req.RunId)
+	if err != nil {
+		return err
+	}
+	cc := r.chromeClient
+
+	sub, replay := cc.SubscribeFrom("", int(req.LastEventId))
+	defer cc.Unsubscribe(sub)
+
+	for _, e := range replay {
+		if pe := toProtoEvent(e); pe != nil {
+			if err := stream.Send(pe); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case e := <-sub.ch:
+			if pe := toProtoEvent(e); pe != nil {
+				if err := stream.Send(pe); err != nil {
+					return err
+				}
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *controlServer) Stop(ctx context.Context, req *controlpb.StopRequest) (*controlpb.StopResponse, error) {
+	r, err := s.getRun(req.RunId)
+	if err != nil {
+		return nil, err
+	}
+	r.chromeClient.Shutdown(nil)
+	return &controlpb.StopResponse{}, nil
+}
+
+func (s *controlServer) GetArtifact(ctx context.Context, req *controlpb.GetArtifactRequest) (*controlpb.GetArtifactResponse, error) {
+	r, err := s.getRun(req.RunId)
+	if err != nil {
+		return nil, err
+	}
+	cc := r.chromeClient
+
+	switch req.Kind {
+	case controlpb.ArtifactKind_SCREENSHOT:
+		data, err := ioutil.ReadFile(path.Join(cc.runDir, "screenshot.png"))
+		if err != nil {
+			return nil, err
+		}
+		return &controlpb.GetArtifactResponse{Data: data, ContentType: "image/png"}, nil
+
+	case controlpb.ArtifactKind_HAR:
+		har := cc.GenerateHAR(runPolicyId(cc))
+		data, err := json.MarshalIndent(map[string]interface{}{"log": har}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return &controlpb.GetArtifactResponse{Data: data, ContentType: "application/json"}, nil
+
+	case controlpb.ArtifactKind_JSON:
+		cc.Lock()
+		data, err := json.MarshalIndent(cc.eventLog, "", "  ")
+		cc.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return &controlpb.GetArtifactResponse{Data: data, ContentType: "application/json"}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown artifact kind: %v", req.Kind)
+	}
+}
+
+// runPolicyId returns the most recently assigned policy id for cc's target.
+// -control mode runs a single URL per run (like main.go's plain -open-url
+// path, not -batch), so there's exactly one to pick for policy-scoped
+// artifacts like HAR.
+func runPolicyId(cc *ChromeClient) string {
+	cc.Lock()
+	defer cc.Unlock()
+
+	if len(cc.policyIds) == 0 {
+		return ""
+	}
+	return cc.policyIds[len(cc.policyIds)-1]
+}
+
+// toProtoEvent converts one of logging.go's event types to the controlpb
+// Event oneof, or returns nil for event types the oneof doesn't model (see
+// exfil_runner.proto).
+func toProtoEvent(e interface{}) *controlpb.Event {
+	switch v := e.(type) {
+	case *NetworkRequestInterceptedEvent:
+		return &controlpb.Event{NetworkRequestIntercepted: &controlpb.NetworkRequestInterceptedEvent{
+			EventId:      int32(v.EventId),
+			PolicyId:     v.PolicyId,
+			TargetId:     v.TargetId,
+			FrameId:      v.FrameId,
+			ResourceType: v.ResourceType,
+			URL:          requestURL(v.Request),
+			PolicyState:  toProtoPolicyState(v.PolicyState),
+		}}
+	case *NetworkRequestWillBeSentEvent:
+		return &controlpb.Event{NetworkRequestWillBeSent: &controlpb.NetworkRequestWillBeSentEvent{
+			EventId:       int32(v.EventId),
+			PolicyId:      v.PolicyId,
+			TargetId:      v.TargetId,
+			RequestId:     v.RequestId,
+			URL:           requestURL(v.Request),
+			ScriptIdStack: scriptIdStackOf(v.Initiator),
+			PolicyState:   toProtoPolicyState(v.PolicyState),
+		}}
+	case *APIAccessEvent:
+		trusted := v.PolicyState != nil && v.PolicyState.StackIsTrusted(v.ScriptIdStack)
+		return &controlpb.Event{APIAccess: &controlpb.APIAccessEvent{
+			EventId:       int32(v.EventId),
+			PolicyId:      v.PolicyId,
+			TargetId:      v.TargetId,
+			APIName:       v.APIName,
+			Trusted:       trusted,
+			ScriptIdStack: v.ScriptIdStack,
+			PolicyState:   toProtoPolicyState(v.PolicyState),
+		}}
+	case *DebuggerScriptParsedEvent:
+		return &controlpb.Event{DebuggerScriptParsed: &controlpb.DebuggerScriptParsedEvent{
+			EventId:  int32(v.EventId),
+			PolicyId: v.PolicyId,
+			TargetId: v.TargetId,
+			ScriptId: v.ScriptId,
+			URL:      v.URL,
+			Hash:     v.Hash,
+		}}
+	case *PageLifecycleEvent:
+		return &controlpb.Event{PageLifecycle: &controlpb.PageLifecycleEvent{
+			EventId:     int32(v.EventId),
+			PolicyId:    v.PolicyId,
+			TargetId:    v.TargetId,
+			FrameId:     v.FrameId,
+			LoaderId:    v.LoaderId,
+			Name:        v.Name,
+			PolicyState: toProtoPolicyState(v.PolicyState),
+		}}
+	default:
+		return nil
+	}
+}
+
+func requestURL(r *NetworkRequest) string {
+	if r == nil {
+		return ""
+	}
+	return r.URL
+}
+
+// toProtoPolicyState flattens a PolicyState's TrustGroups down to the
+// name + script ids a StreamEvents caller actually needs, combining each
+// group's RemoteScripts and InlineScripts the same way Policy.TrustedScriptIds
+// does in policy.go.
+func toProtoPolicyState(s *PolicyState) *controlpb.PolicyState {
+	if s == nil {
+		return nil
+	}
+
+	ret := &controlpb.PolicyState{Id: s.PolicyId}
+	for _, tg := range s.TrustGroups {
+		name := "untrusted"
+		if tg.Trusted {
+			name = "trusted"
+		}
+
+		var ids []string
+		for _, rs := range tg.RemoteScripts {
+			ids = append(ids, rs.ScriptId)
+		}
+		for _, is := range tg.InlineScripts {
+			ids = append(ids, is.ScriptId)
+		}
+
+		ret.TrustGroups = append(ret.TrustGroups, &controlpb.TrustGroupState{Name: name, ScriptIds: ids})
+	}
+	return ret
+}
@@ -0,0 +1,36 @@
+package controlpb
+
+import (
+	"encoding/json"
+)
+
+// jsonCodec implements grpc's encoding.Codec (Marshal/Unmarshal/Name) with
+// encoding/json instead of real protobuf wire encoding, since none of
+// controlpb.go's hand-rolled types implement proto.Message. See
+// controlpb.go for why this package isn't protoc-generated in the first
+// place.
+//
+// It's named "json", not "proto": grpc-go's built-in "proto" codec is the
+// process-wide default for every service, and this package must not steal
+// that name out from under some other real protobuf service that happens
+// to share the binary. Codec is applied to this service specifically via
+// grpc.ForceServerCodec (see runControlServer in control_server.go) instead
+// of through the global encoding.RegisterCodec registry.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+// Codec is the encoding.Codec ExfilRunner's gRPC server (and any dialer
+// that wants to talk to it) must install explicitly, since it's no longer
+// registered globally.
+var Codec = jsonCodec{}
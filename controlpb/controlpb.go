@@ -0,0 +1,136 @@
+// Package controlpb holds the Go types generated from exfil_runner.proto.
+//
+// This file is hand-maintained rather than protoc-generated: the sandbox
+// this tree was developed in doesn't have protoc or the protoc-gen-go /
+// protoc-gen-go-grpc plugins available. It mirrors exfil_runner.proto
+// closely enough for control_server.go to compile and run against, but
+// none of these types implement proto.Message (no Reset/ProtoReflect), so
+// they can't go through grpc's default "proto" codec. codec.go provides
+// a replacement codec, named "json", that marshals with encoding/json
+// instead and is installed on the server via grpc.ForceServerCodec, so a
+// Go client that installs the same codec can talk to a Go server here
+// end to end; it is NOT wire-compatible with a real
+// protobuf client. Regenerate this package for real before this is used
+// against a non-Go client:
+//
+//	protoc --go_out=. --go-grpc_out=. controlpb/exfil_runner.proto
+//
+// and delete this file and codec.go.
+package controlpb
+
+type RunOptions struct {
+	Headless      bool
+	Enforce       bool
+	HarOut        bool
+	HostnameMatch string
+}
+
+type StartRunRequest struct {
+	Policy  int32
+	URL     string
+	Options *RunOptions
+}
+
+type RunHandle struct {
+	RunId    string
+	PolicyId string
+}
+
+type StreamEventsRequest struct {
+	RunId       string
+	LastEventId int32
+}
+
+type StopRequest struct {
+	RunId string
+}
+
+type StopResponse struct{}
+
+type ArtifactKind int32
+
+const (
+	ArtifactKind_SCREENSHOT ArtifactKind = 0
+	ArtifactKind_HAR        ArtifactKind = 1
+	ArtifactKind_JSON       ArtifactKind = 2
+)
+
+type GetArtifactRequest struct {
+	RunId string
+	Kind  ArtifactKind
+}
+
+type GetArtifactResponse struct {
+	Data        []byte
+	ContentType string
+}
+
+type PolicyState struct {
+	Id            string
+	TrustGroups   []*TrustGroupState
+	LearnedOrigin string
+}
+
+type TrustGroupState struct {
+	Name      string
+	ScriptIds []string
+}
+
+type NetworkRequestInterceptedEvent struct {
+	EventId      int32
+	PolicyId     string
+	TargetId     string
+	FrameId      string
+	ResourceType string
+	URL          string
+	PolicyState  *PolicyState
+}
+
+type NetworkRequestWillBeSentEvent struct {
+	EventId       int32
+	PolicyId      string
+	TargetId      string
+	RequestId     string
+	URL           string
+	ScriptIdStack []string
+	PolicyState   *PolicyState
+}
+
+type APIAccessEvent struct {
+	EventId       int32
+	PolicyId      string
+	TargetId      string
+	APIName       string
+	Trusted       bool
+	ScriptIdStack []string
+	PolicyState   *PolicyState
+}
+
+type DebuggerScriptParsedEvent struct {
+	EventId  int32
+	PolicyId string
+	TargetId string
+	ScriptId string
+	URL      string
+	Hash     string
+}
+
+type PageLifecycleEvent struct {
+	EventId     int32
+	PolicyId    string
+	TargetId    string
+	FrameId     string
+	LoaderId    string
+	Name        string
+	PolicyState *PolicyState
+}
+
+// Event is a oneof over the five pre-gRPC logging.go event types; exactly
+// one of these fields is set.
+type Event struct {
+	NetworkRequestIntercepted *NetworkRequestInterceptedEvent
+	NetworkRequestWillBeSent  *NetworkRequestWillBeSentEvent
+	APIAccess                 *APIAccessEvent
+	DebuggerScriptParsed      *DebuggerScriptParsedEvent
+	PageLifecycle             *PageLifecycleEvent
+}
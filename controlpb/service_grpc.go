@@ -0,0 +1,122 @@
+package controlpb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExfilRunnerServer is the server API for ExfilRunner, matching what
+// protoc-gen-go-grpc would emit from the service definition in
+// exfil_runner.proto.
+type ExfilRunnerServer interface {
+	StartRun(context.Context, *StartRunRequest) (*RunHandle, error)
+	StreamEvents(*StreamEventsRequest, ExfilRunner_StreamEventsServer) error
+	Stop(context.Context, *StopRequest) (*StopResponse, error)
+	GetArtifact(context.Context, *GetArtifactRequest) (*GetArtifactResponse, error)
+}
+
+// UnimplementedExfilRunnerServer can be embedded in a server implementation
+// for forward compatibility with future methods added to the service.
+type UnimplementedExfilRunnerServer struct{}
+
+func (UnimplementedExfilRunnerServer) StartRun(context.Context, *StartRunRequest) (*RunHandle, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartRun not implemented")
+}
+func (UnimplementedExfilRunnerServer) StreamEvents(*StreamEventsRequest, ExfilRunner_StreamEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedExfilRunnerServer) Stop(context.Context, *StopRequest) (*StopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
+}
+func (UnimplementedExfilRunnerServer) GetArtifact(context.Context, *GetArtifactRequest) (*GetArtifactResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetArtifact not implemented")
+}
+
+// ExfilRunner_StreamEventsServer is the server-side stream handle for
+// StreamEvents, analogous to a generated <Service>_<Method>Server type.
+type ExfilRunner_StreamEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+func RegisterExfilRunnerServer(s *grpc.Server, srv ExfilRunnerServer) {
+	s.RegisterService(&_ExfilRunner_serviceDesc, srv)
+}
+
+func _ExfilRunner_StartRun_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartRunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExfilRunnerServer).StartRun(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/controlpb.ExfilRunner/StartRun"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExfilRunnerServer).StartRun(ctx, req.(*StartRunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExfilRunner_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExfilRunnerServer).StreamEvents(m, &exfilRunnerStreamEventsServer{stream})
+}
+
+type exfilRunnerStreamEventsServer struct {
+	grpc.ServerStream
+}
+
+func (s *exfilRunnerStreamEventsServer) Send(e *Event) error {
+	return s.ServerStream.SendMsg(e)
+}
+
+func _ExfilRunner_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExfilRunnerServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/controlpb.ExfilRunner/Stop"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExfilRunnerServer).Stop(ctx, req.(*StopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExfilRunner_GetArtifact_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetArtifactRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExfilRunnerServer).GetArtifact(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/controlpb.ExfilRunner/GetArtifact"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExfilRunnerServer).GetArtifact(ctx, req.(*GetArtifactRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _ExfilRunner_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "controlpb.ExfilRunner",
+	HandlerType: (*ExfilRunnerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "StartRun", Handler: _ExfilRunner_StartRun_Handler},
+		{MethodName: "Stop", Handler: _ExfilRunner_Stop_Handler},
+		{MethodName: "GetArtifact", Handler: _ExfilRunner_GetArtifact_Handler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "StreamEvents", Handler: _ExfilRunner_StreamEvents_Handler, ServerStreams: true},
+	},
+	Metadata: "controlpb/exfil_runner.proto",
+}
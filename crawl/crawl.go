@@ -2,52 +2,204 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/csv"
+	"encoding/xml"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/tebeka/selenium"
 	"github.com/tebeka/selenium/chrome"
+	"github.com/temoto/robotstxt"
+	"golang.org/x/time/rate"
 )
 
 const numDomains = 500
 const (
 	seleniumPath     = "selenium-server-standalone-3.141.59.jar"
 	chromeDriverPath = "chromedriver"
-	port             = 8765
+	basePort         = 8765
+	domainCrawlLimit = 100
+	crawlerUserAgent = "webexfil-crawler"
 )
 
+var concurrency int
+var defaultRate float64
+
+func init() {
+	flag.IntVar(&concurrency, "concurrency", 4, "number of crawl workers to run concurrently, each with at most one Chrome session open at a time")
+	flag.Float64Var(&defaultRate, "rate", 1.0, "default max requests per second per domain, overridden by a domain's robots.txt Crawl-delay")
+	flag.Parse()
+}
+
 type crawlItem struct {
 	domain string
 	url    string
 	depth  int
 }
 
+// domainURLSet is the shared, lock-protected accumulator every worker
+// writes discovered URLs into.
+type domainURLSet struct {
+	sync.Mutex
+	m map[string][]string
+}
+
+func newDomainURLSet() *domainURLSet {
+	return &domainURLSet{m: make(map[string][]string)}
+}
+
+// politeness tracks, per domain, a token-bucket rate limiter (seeded from
+// the domain's robots.txt Crawl-delay when present) and the parsed
+// robots.txt itself so workers can honor Disallow rules.
+type politeness struct {
+	sync.Mutex
+	limiters map[string]*rate.Limiter
+	robots   map[string]*robotstxt.RobotsData
+}
+
+func newPoliteness() *politeness {
+	return &politeness{
+		limiters: make(map[string]*rate.Limiter),
+		robots:   make(map[string]*robotstxt.RobotsData),
+	}
+}
+
+func (pn *politeness) limiterFor(domain string) *rate.Limiter {
+	pn.Lock()
+	defer pn.Unlock()
+
+	if l, ok := pn.limiters[domain]; ok {
+		return l
+	}
+
+	robots := fetchRobots(domain)
+	pn.robots[domain] = robots
+
+	rps := defaultRate
+	if robots != nil {
+		if group := robots.FindGroup(crawlerUserAgent); group != nil && group.CrawlDelay > 0 {
+			rps = 1 / group.CrawlDelay.Seconds()
+		}
+	}
+
+	l := rate.NewLimiter(rate.Limit(rps), 1)
+	pn.limiters[domain] = l
+	return l
+}
+
+func (pn *politeness) allowed(domain string, pageURL string) bool {
+	pn.Lock()
+	robots := pn.robots[domain]
+	pn.Unlock()
+
+	if robots == nil {
+		return true
+	}
+	return robots.TestAgent(pageURL, crawlerUserAgent)
+}
+
+func fetchRobots(domain string) *robotstxt.RobotsData {
+	resp, err := http.Get(fmt.Sprintf("https://%v/robots.txt", domain))
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	robots, err := robotstxt.FromResponse(resp)
+	if err != nil {
+		log.Println("Bad robots.txt:", domain, err)
+		return nil
+	}
+	return robots
+}
+
+type sitemapURLSet struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+// discoverSitemapURLs fetches a domain's sitemap.xml, if any, so the queue
+// can be seeded with it ahead of link-discovery BFS.
+func discoverSitemapURLs(domain string) []string {
+	resp, err := http.Get(fmt.Sprintf("https://%v/sitemap.xml", domain))
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil
+	}
+
+	var set sitemapURLSet
+	if err := xml.Unmarshal(b, &set); err != nil {
+		return nil
+	}
+
+	urls := []string{}
+	for _, u := range set.URLs {
+		urls = append(urls, u.Loc)
+	}
+	return urls
+}
+
 func main() {
 	domains := domainList()
 
-	q := []crawlItem{}
+	q := make(chan crawlItem, 100000)
+	var qWg sync.WaitGroup
+	pn := newPoliteness()
+	domainURLs := newDomainURLSet()
+
+	enqueue := func(item crawlItem) {
+		qWg.Add(1)
+		q <- item
+	}
+
 	for _, domain := range domains {
-		q = append(q, crawlItem{
-			domain: domain,
-			url:    fmt.Sprintf("https://%v/", domain),
-			depth:  0,
-		})
+		enqueue(crawlItem{domain: domain, url: fmt.Sprintf("https://%v/", domain), depth: 0})
+
+		for _, u := range discoverSitemapURLs(domain) {
+			enqueue(crawlItem{domain: domain, url: u, depth: 1})
+		}
 	}
 
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func(worker int) {
+			defer workers.Done()
+			crawlWorker(worker, q, &qWg, pn, domainURLs, enqueue)
+		}(i)
+	}
+
+	go func() {
+		qWg.Wait()
+		close(q)
+	}()
+
+	workers.Wait()
+
 	f, err := os.Create("urls.txt")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer f.Close()
 
-	domainURLS := sampleURLs(crawl(q), 5)
+	domainURLSample := sampleURLs(domainURLs.m, 5)
 
-	for domain, urls := range domainURLS {
+	for domain, urls := range domainURLSample {
 		for _, url := range urls {
 			f.WriteString(fmt.Sprintf("%v %v\n", domain, url))
 		}
@@ -132,12 +284,19 @@ func sampleURLs(domainURLs map[string][]string, n int) map[string][]string {
 	return ret
 }
 
-func crawl(q []crawlItem) map[string][]string {
+// crawlWorker owns a single long-lived Selenium service on its own port and
+// drains crawlItems off q until it's closed, respecting each domain's
+// robots.txt and rate limit and re-queuing newly discovered same-domain
+// links for other workers to pick up. Each item gets its own Chrome
+// WebDriver session, which crawlOne opens and quits; no session is kept
+// alive from one item to the next.
+func crawlWorker(worker int, q chan crawlItem, qWg *sync.WaitGroup, pn *politeness, domainURLs *domainURLSet, enqueue func(crawlItem)) {
+	port := basePort + worker
+
 	opts := []selenium.ServiceOption{
 		selenium.ChromeDriver(chromeDriverPath),
 		selenium.Output(os.Stderr),
 	}
-
 	service, err := selenium.NewSeleniumService(seleniumPath, port, opts...)
 	if err != nil {
 		log.Fatal(err)
@@ -159,90 +318,102 @@ func crawl(q []crawlItem) map[string][]string {
 		},
 	})
 
-	domainURLs := make(map[string][]string)
-	domainCrawlLimit := 100
+	for item := range q {
+		crawlOne(worker, port, caps, item, qWg, pn, domainURLs, enqueue)
+	}
+}
 
-	for len(q) > 0 {
-		c := q[0]
-		q = q[1:]
+func crawlOne(worker int, port int, caps selenium.Capabilities, item crawlItem, qWg *sync.WaitGroup, pn *politeness, domainURLs *domainURLSet, enqueue func(crawlItem)) {
+	defer qWg.Done()
 
-		if len(domainURLs[c.domain]) == 0 {
-			domainURLs[c.domain] = []string{c.url}
+	domainURLs.Lock()
+	seen := false
+	for _, existing := range domainURLs.m[item.domain] {
+		if existing == item.url {
+			seen = true
+			break
 		}
+	}
+	if !seen {
+		domainURLs.m[item.domain] = append(domainURLs.m[item.domain], item.url)
+	}
+	atLimit := len(domainURLs.m[item.domain]) >= domainCrawlLimit
+	domainURLs.Unlock()
 
-		if len(domainURLs[c.domain]) >= domainCrawlLimit {
-			continue
-		}
+	if atLimit || item.depth > 2 {
+		return
+	}
 
-		if c.depth > 2 {
-			continue
-		}
+	limiter := pn.limiterFor(item.domain)
+	if !pn.allowed(item.domain, item.url) {
+		log.Println("Disallowed by robots.txt:", item.url)
+		return
+	}
+	limiter.Wait(context.Background())
 
-		wd, err := selenium.NewRemote(caps, fmt.Sprintf("http://localhost:%d/wd/hub", port))
-		if err != nil {
-			log.Fatal(err)
-		}
+	wd, err := selenium.NewRemote(caps, fmt.Sprintf("http://localhost:%d/wd/hub", port))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer wd.Quit()
 
-		wd.SetPageLoadTimeout(15 * time.Second)
+	wd.SetPageLoadTimeout(15 * time.Second)
 
-		p := 0
-		for _, urls := range domainURLs {
-			p += len(urls)
-		}
-		log.Printf("Progress: %v/%v", p, domainCrawlLimit*numDomains)
+	p := 0
+	domainURLs.Lock()
+	for _, urls := range domainURLs.m {
+		p += len(urls)
+	}
+	domainURLs.Unlock()
+	log.Printf("[worker %v] Progress: %v/%v", worker, p, domainCrawlLimit*numDomains)
+
+	log.Printf("[worker %v] Fetching: %v %v", worker, item.depth, item.url)
+	if err := wd.Get(item.url); err != nil {
+		log.Printf("Fetch error: %v %v %v: %v", item.domain, item.depth, item.url, err)
+		return
+	}
+
+	links, err := wd.FindElements(selenium.ByCSSSelector, "a[href]")
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
-		log.Println("Fetching:", c.depth, c.url)
-		if err := wd.Get(c.url); err != nil {
+linkLoop:
+	for _, link := range links {
+		href, err := link.GetAttribute("href")
+		if err != nil {
 			log.Print(err)
-			log.Printf("Fetch error: %v %v %v", c.domain, c.depth, c.url)
 			continue
 		}
 
-		links, err := wd.FindElements(selenium.ByCSSSelector, "a[href]")
+		pattern := fmt.Sprintf("^https?://([a-z0-9\\.]+\\.)?%v/", regexp.QuoteMeta(item.domain))
+		matched, err := regexp.MatchString(pattern, href)
 		if err != nil {
-			log.Print(err)
+			log.Fatal(err)
+		}
+		if !matched {
 			continue
 		}
 
-	linkLoop:
-		for _, link := range links {
-			url, err := link.GetAttribute("href")
-			if err != nil {
-				log.Print(err)
-				continue
+		domainURLs.Lock()
+		for _, existing := range domainURLs.m[item.domain] {
+			if href == existing {
+				domainURLs.Unlock()
+				continue linkLoop
 			}
+		}
 
-			pattern := fmt.Sprintf("^https?://([a-z0-9\\.]+\\.)?%v/", c.domain)
-			matched, err := regexp.MatchString(pattern, url)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if !matched {
-				continue
-			}
-
-			for _, url2 := range domainURLs[c.domain] {
-				if url == url2 {
-					continue linkLoop
-				}
-			}
-
-			log.Println("Found:", url)
-			domainURLs[c.domain] = append(domainURLs[c.domain], url)
-
-			if len(domainURLs[c.domain]) >= domainCrawlLimit {
-				break
-			}
+		log.Println("Found:", href)
+		domainURLs.m[item.domain] = append(domainURLs.m[item.domain], href)
+		full := len(domainURLs.m[item.domain]) >= domainCrawlLimit
+		domainURLs.Unlock()
 
-			q = append(q, crawlItem{
-				domain: c.domain,
-				url:    url,
-				depth:  c.depth + 1,
-			})
+		if full {
+			break
 		}
 
-		wd.Quit()
+		enqueue(crawlItem{domain: item.domain, url: href, depth: item.depth + 1})
 	}
-
-	return domainURLs
 }
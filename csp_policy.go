@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// cspDirectives lists the Content-Security-Policy-style directives a
+// -csp-policy config file may set; anything else is a parse error.
+var cspDirectives = map[string]bool{
+	"default-src": true, "script-src": true, "connect-src": true,
+	"frame-src": true, "form-action": true, "img-src": true,
+	"media-src": true, "object-src": true,
+}
+
+// CSPPolicy is a declarative allowlist modeled on the Content-Security-Policy
+// header: each directive names the source expressions ('self', 'none',
+// scheme prefixes like "https:", and host globs like "*.example.com") that
+// may satisfy a request or API access of that resource type, falling back
+// to default-src the same way a browser does when a specific directive
+// isn't set.
+type CSPPolicy struct {
+	directives map[string][]string
+}
+
+// ParseCSPPolicy parses a CSP-header-shaped config: directives separated by
+// ";", each a directive name followed by whitespace-separated source
+// expressions, e.g.:
+//
+//	default-src 'none'; script-src 'self' https://cdn.example.com; connect-src 'self'
+func ParseCSPPolicy(text string) (*CSPPolicy, error) {
+	p := &CSPPolicy{directives: map[string][]string{}}
+
+	for _, part := range strings.Split(text, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+
+		name := strings.ToLower(fields[0])
+		if !cspDirectives[name] {
+			return nil, fmt.Errorf("csp policy: unknown directive %q", fields[0])
+		}
+		p.directives[name] = fields[1:]
+	}
+
+	return p, nil
+}
+
+// LoadCSPPolicy reads and parses the policy config at configPath.
+func LoadCSPPolicy(configPath string) (*CSPPolicy, error) {
+	b, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCSPPolicy(string(b))
+}
+
+// sources returns directive's source list, falling back to default-src the
+// way CSP does when directive itself isn't set. ok is false if neither
+// directive nor default-src appears in the policy at all, meaning that
+// resource type is left unrestricted.
+func (p *CSPPolicy) sources(directive string) (sources []string, ok bool) {
+	if s, present := p.directives[directive]; present {
+		return s, true
+	}
+	if s, present := p.directives["default-src"]; present {
+		return s, true
+	}
+	return nil, false
+}
+
+// Allows reports whether rawURL is permitted under directive, given the
+// page's own origin (for 'self').
+func (p *CSPPolicy) Allows(directive string, rawURL string, pageOrigin string) bool {
+	sources, ok := p.sources(directive)
+	if !ok {
+		return true
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	for _, s := range sources {
+		if matchCSPSource(s, u, pageOrigin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAnyAPIAccess reports whether directive permits an API access at
+// all. API accesses (document.cookie, etc.) don't carry a destination URL
+// the way a network request does, so host-based source expressions can't
+// be matched against them; the only thing this module can enforce for them
+// is a directive that's fully closed with 'none'.
+func (p *CSPPolicy) allowsAnyAPIAccess(directive string) bool {
+	sources, ok := p.sources(directive)
+	if !ok {
+		return true
+	}
+	for _, s := range sources {
+		if s != "'none'" {
+			return true
+		}
+	}
+	return false
+}
+
+func matchCSPSource(source string, u *url.URL, pageOrigin string) bool {
+	switch source {
+	case "'none'":
+		return false
+	case "'self'":
+		pu, err := url.Parse(pageOrigin)
+		return err == nil && pu.Scheme == u.Scheme && pu.Host == u.Host
+	}
+
+	if strings.HasSuffix(source, ":") {
+		return strings.TrimSuffix(source, ":") == u.Scheme
+	}
+
+	host := source
+	if idx := strings.Index(source, "://"); idx >= 0 {
+		if source[:idx] != u.Scheme {
+			return false
+		}
+		host = source[idx+3:]
+	}
+
+	if strings.HasPrefix(host, "*.") {
+		return u.Host == host[2:] || strings.HasSuffix(u.Host, host[1:])
+	}
+	return u.Host == host
+}
+
+// CSPViolation mirrors the fields of a browser's SecurityPolicyViolationEvent
+// (violatedDirective, blockedURI, sourceFile, lineNumber, sample) for one
+// request or API access this module denied.
+type CSPViolation struct {
+	PolicyId          string
+	ViolatedDirective string
+	BlockedURI        string
+	ResourceType      string
+	SourceFile        string
+	LineNumber        int
+	Sample            string
+}
+
+// directiveForResourceType maps a Network.resourceType to the CSP directive
+// that governs it, mirroring the fetch-destination-to-directive mapping a
+// browser uses to enforce a real Content-Security-Policy header.
+func directiveForResourceType(resourceType string) string {
+	switch strings.ToLower(resourceType) {
+	case "script":
+		return "script-src"
+	case "xhr", "fetch", "websocket", "eventsource", "ping":
+		return "connect-src"
+	case "image", "imageset":
+		return "img-src"
+	case "media":
+		return "media-src"
+	case "object", "embed":
+		return "object-src"
+	case "document", "iframe", "subframe":
+		return "frame-src"
+	default:
+		return "default-src"
+	}
+}
+
+// isFormSubmission reports whether req looks like a form POST rather than
+// an ordinary document navigation. Network.resourceType is "Document" for
+// both, so directiveForResourceType alone can't tell them apart; Method/
+// HasPostData (set by NewNetworkRequest in logging.go) can.
+func isFormSubmission(req *NetworkRequest) bool {
+	return strings.EqualFold(req.Method, "POST") || req.HasPostData
+}
+
+// directiveForAPIName maps a sensitive-API name (the exfiltration_* names
+// AnalyzePolicy checks in analysis.go, or a js/shims.txt entry that
+// installInstrumentation hooks as a shim_* function) to the CSP directive
+// that corresponds to it.
+func directiveForAPIName(apiName string) string {
+	lower := strings.ToLower(apiName)
+	switch {
+	case strings.Contains(lower, "fetch"), strings.Contains(lower, "xmlhttprequest"), strings.Contains(lower, "websocket"), strings.Contains(lower, "sendbeacon"), strings.Contains(lower, "eventsource"), strings.Contains(lower, "rtcdatachannel"):
+		return "connect-src"
+	case strings.Contains(lower, "image"):
+		return "img-src"
+	case strings.Contains(lower, "form"):
+		return "form-action"
+	default:
+		return "default-src"
+	}
+}
+
+// EvaluateRun checks every NetworkRequestWillBeSentEvent and APIAccessEvent
+// logged for pid against p and returns one CSPViolation per denied event.
+func (p *CSPPolicy) EvaluateRun(cc *ChromeClient, pid string) []CSPViolation {
+	cc.Lock()
+	defer cc.Unlock()
+
+	var violations []CSPViolation
+
+	for _, e := range cc.NetworkRequestWillBeSentLogs(pid) {
+		if e.Request == nil {
+			continue
+		}
+
+		directive := directiveForResourceType(e.ResourceType)
+		if directive == "frame-src" && isFormSubmission(e.Request) {
+			directive = "form-action"
+		}
+		if p.Allows(directive, e.Request.URL, e.DocumentURL) {
+			continue
+		}
+
+		v := CSPViolation{
+			PolicyId:          pid,
+			ViolatedDirective: directive,
+			BlockedURI:        e.Request.URL,
+			ResourceType:      e.ResourceType,
+		}
+		if e.Initiator != nil {
+			v.SourceFile = e.Initiator.URL
+			v.LineNumber = e.Initiator.LineNumber
+		}
+		violations = append(violations, v)
+	}
+
+	for _, e := range cc.APIAccessLogs(pid) {
+		directive := directiveForAPIName(e.APIName)
+		if p.allowsAnyAPIAccess(directive) {
+			continue
+		}
+
+		violations = append(violations, CSPViolation{
+			PolicyId:          pid,
+			ViolatedDirective: directive,
+			BlockedURI:        e.APIName,
+			ResourceType:      "api",
+			Sample:            strings.Join(e.ScriptIdStack, ","),
+		})
+	}
+
+	return violations
+}
+
+// WriteCSPReportsToFile evaluates policy against every policy id seen
+// during this run and writes one JSON report (mirroring how a server would
+// receive Content-Security-Policy-Report-Only reports) to
+// csp_violations.json next to events.json, the way -har-out writes
+// events.har. It returns whether any violation was found, so a
+// non-report-only caller can decide to fail the run.
+func (cc *ChromeClient) WriteCSPReportsToFile(policy *CSPPolicy, reportOnly bool) bool {
+	var violations []CSPViolation
+	for _, pid := range cc.policyIds {
+		violations = append(violations, policy.EvaluateRun(cc, pid)...)
+	}
+
+	report := struct {
+		ReportOnly bool           `json:"reportOnly"`
+		Violations []CSPViolation `json:"violations"`
+	}{ReportOnly: reportOnly, Violations: violations}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := ioutil.WriteFile(path.Join(cc.logsDir, "csp_violations.json"), b, 0644); err != nil {
+		log.Panic(err)
+	}
+
+	return len(violations) > 0
+}
+
+var cspPolicyOnce sync.Once
+var cachedCSPPolicy *CSPPolicy
+
+// loadConfiguredCSPPolicy lazily parses -csp-policy once per process and
+// returns nil if it's unset.
+func loadConfiguredCSPPolicy() *CSPPolicy {
+	cspPolicyOnce.Do(func() {
+		if cspPolicyPath == "" {
+			return
+		}
+		p, err := LoadCSPPolicy(cspPolicyPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cachedCSPPolicy = p
+	})
+	return cachedCSPPolicy
+}
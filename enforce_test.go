@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// freeTestPort grabs an ephemeral port for the duration of NewChromeClient's
+// own bind, the same two-port convention every other caller (runURL,
+// runBatchWorkerAttempt) uses, just chosen dynamically instead of off
+// -remote-debugging-port/-web-server-port so tests can run concurrently
+// without colliding.
+func freeTestPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// TestFilterListBlocksThirdPartyAnalytics drives a real ChromeClient under
+// -policy=3 (filterlist) with -enforce against
+// /Policy3/TestThirdPartyAnalytics/Fail and asserts the google-analytics.com
+// request is actually blocked, not just flagged after the fact by
+// AnalyzePolicy. It points -filter-lists at a throwaway list instead of the
+// repo's default filter_lists/easylist.txt,filter_lists/easyprivacy.txt, so
+// it doesn't depend on those (large, separately-fetched) lists being
+// present in the environment running the test.
+func TestFilterListBlocksThirdPartyAnalytics(t *testing.T) {
+	listPath := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(listPath, []byte("||google-analytics.com^\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filterListsFlag = listPath
+
+	enforceMode = true
+	defer func() { enforceMode = false }()
+
+	rd := t.TempDir()
+	cc := NewChromeClient(rd, policyTypeFilterList, freeTestPort(t), freeTestPort(t))
+
+	cc.WaitReady()
+	if err := cc.OpenURL(cc.WebServerURL("/Policy3/TestThirdPartyAnalytics/Fail")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.WaitStopped(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cc.policyIds) == 0 {
+		t.Fatal("expected a policy id from the run")
+	}
+	pid := cc.policyIds[0]
+
+	// Give AnalyzePolicy's usual event log a moment to settle; the blocked
+	// event is logged synchronously from fetchRequestPaused, but
+	// WaitStopped only guarantees the page fired its load lifecycle event.
+	time.Sleep(time.Second)
+
+	if len(cc.NetworkRequestBlockedLogs(pid)) == 0 {
+		t.Error("expected google-analytics.com request to be blocked under policyTypeFilterList enforce mode")
+	}
+}
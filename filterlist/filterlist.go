@@ -0,0 +1,262 @@
+// Package filterlist parses EasyList/EasyPrivacy-format Adblock Plus filter
+// lists and matches network requests against them, so policy.go's
+// FilterListScriptSet (trust_group.go) can mark ad/analytics scripts
+// untrusted without depending on an external adblock engine.
+//
+// Matching is two-stage: Request hostnames are first looked up in a
+// hostname trie built from plain "||domain^" rules (the bulk of most
+// lists), and only the rightmost-label bucket of the remaining
+// regex/glob rules is tested against the request, rather than the full
+// rule set.
+package filterlist
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Request is the subset of a network request filterlist needs to decide
+// whether a rule matches it.
+type Request struct {
+	URL          string
+	ResourceType string // lowercased CDP resourceType, e.g. "script", "image"
+	PageOrigin   string // scheme://host of the page the request was made from
+}
+
+// trieNode is one label of the hostname trie, keyed root-to-leaf by domain
+// labels in reverse (e.g. "example.com" is inserted as com -> example), so
+// that a rule for "example.com" also matches "ads.example.com".
+type trieNode struct {
+	children map[string]*trieNode
+	rules    []*rule
+}
+
+// Matcher is a compiled set of filter list rules. It's safe for concurrent
+// use; Reload atomically swaps in a freshly parsed rule set so it can be
+// hot-reloaded from a background goroutine while requests are being
+// matched from other goroutines.
+type Matcher struct {
+	mu   sync.RWMutex
+	root *trieNode
+	// buckets indexes every non-hostnameOnly rule by rightmostLabel of its
+	// pattern; "" holds rules with no literal domain label to key off of
+	// and is always consulted.
+	buckets map[string][]*rule
+}
+
+// NewMatcher returns an empty Matcher; call Load to populate it.
+func NewMatcher() *Matcher {
+	return &Matcher{
+		root:    &trieNode{children: map[string]*trieNode{}},
+		buckets: map[string][]*rule{},
+	}
+}
+
+// Load parses every path and replaces the Matcher's rule set atomically.
+// A parse error in one line is logged-equivalent by being returned wrapped
+// with the offending path; callers that want to tolerate a bad list entry
+// should catch and ignore the error per-path instead of calling Load with
+// the whole set.
+func (m *Matcher) Load(paths []string) error {
+	root := &trieNode{children: map[string]*trieNode{}}
+	buckets := map[string][]*rule{}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("filterlist: %w", err)
+		}
+
+		s := bufio.NewScanner(f)
+		lineNo := 0
+		for s.Scan() {
+			lineNo++
+			r, err := parseLine(s.Text())
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("filterlist: %v:%d: %w", path, lineNo, err)
+			}
+			if r == nil {
+				continue
+			}
+
+			if r.hostnameOnly != "" {
+				insertHostname(root, r.hostnameOnly, r)
+			} else {
+				buckets[r.bucket] = append(buckets[r.bucket], r)
+			}
+		}
+		err = s.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("filterlist: %v: %w", path, err)
+		}
+	}
+
+	m.mu.Lock()
+	m.root = root
+	m.buckets = buckets
+	m.mu.Unlock()
+	return nil
+}
+
+func insertHostname(root *trieNode, host string, r *rule) {
+	labels := strings.Split(strings.ToLower(host), ".")
+	node := root
+	for i := len(labels) - 1; i >= 0; i-- {
+		label := labels[i]
+		child, ok := node.children[label]
+		if !ok {
+			child = &trieNode{children: map[string]*trieNode{}}
+			node.children[label] = child
+		}
+		node = child
+	}
+	node.rules = append(node.rules, r)
+}
+
+// hostnameMatches walks root from host's shortest suffix (its top-level
+// label) up to host itself, collecting every rule attached along the way:
+// a rule on "example.com" matches both "example.com" and "ads.example.com".
+func hostnameMatches(root *trieNode, host string) []*rule {
+	labels := strings.Split(strings.ToLower(host), ".")
+	node := root
+	var matched []*rule
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, ok := node.children[labels[i]]
+		if !ok {
+			break
+		}
+		matched = append(matched, child.rules...)
+		node = child
+	}
+	return matched
+}
+
+// Match reports whether req should be treated as matching a blocking
+// (non-exception) network rule: at least one non-exception rule matches it
+// and no exception ("@@") rule also matches it.
+func (m *Matcher) Match(req Request) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return false
+	}
+
+	candidates := hostnameMatches(m.root, u.Hostname())
+	candidates = append(candidates, m.buckets[rightmostLabel(u.Hostname())]...)
+	candidates = append(candidates, m.buckets[""]...)
+
+	thirdParty := isThirdParty(req.PageOrigin, req.URL)
+
+	blocked := false
+	for _, r := range candidates {
+		if !r.appliesTo(req, thirdParty) {
+			continue
+		}
+		if r.pattern != nil && !r.pattern.MatchString(req.URL) {
+			continue
+		}
+		if r.exception {
+			return false
+		}
+		blocked = true
+	}
+	return blocked
+}
+
+func (r *rule) appliesTo(req Request, thirdParty bool) bool {
+	if len(r.resourceTypes) > 0 && req.ResourceType != "" && !r.resourceTypes[req.ResourceType] {
+		return false
+	}
+	if r.thirdParty != nil && *r.thirdParty != thirdParty {
+		return false
+	}
+	if len(r.domainInclude) > 0 && !hostInList(req.PageOrigin, r.domainInclude) {
+		return false
+	}
+	if len(r.domainExclude) > 0 && hostInList(req.PageOrigin, r.domainExclude) {
+		return false
+	}
+	return true
+}
+
+func hostInList(origin string, hosts []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, h := range hosts {
+		if u.Hostname() == h || strings.HasSuffix(u.Hostname(), "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// isThirdParty reports whether requestURL's eTLD+1 differs from
+// pageOrigin's, the same notion of third-partyness EasyList's
+// third-party/~third-party options use.
+func isThirdParty(pageOrigin string, requestURL string) bool {
+	pu, err1 := url.Parse(pageOrigin)
+	ru, err2 := url.Parse(requestURL)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	pageDomain, err1 := publicsuffix.EffectiveTLDPlusOne(pu.Hostname())
+	reqDomain, err2 := publicsuffix.EffectiveTLDPlusOne(ru.Hostname())
+	if err1 != nil || err2 != nil {
+		return pu.Hostname() != ru.Hostname()
+	}
+	return pageDomain != reqDomain
+}
+
+// compileGlob turns an Adblock Plus pattern (wildcards "*", the
+// "^" separator placeholder, and "|" start/end anchors) into a regexp.
+// A bare "||domain^" rule never reaches here (rule.compile routes it to the
+// hostname trie), but a "||domain/path..." rule does, so the "||" anchor
+// is handled here too.
+func compileGlob(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?i)")
+
+	if strings.HasPrefix(pattern, "||") {
+		b.WriteString(`^[a-z-]+://([a-z0-9-]+\.)?`)
+		pattern = pattern[2:]
+	} else if strings.HasPrefix(pattern, "|") {
+		b.WriteString("^")
+		pattern = pattern[1:]
+	}
+
+	anchoredEnd := strings.HasSuffix(pattern, "|")
+	if anchoredEnd {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '^':
+			b.WriteString(`(?:[^a-zA-Z0-9_.%-]|$)`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	if anchoredEnd {
+		b.WriteString("$")
+	}
+
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,124 @@
+package filterlist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func matcherFromLines(t *testing.T, lines ...string) *Matcher {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "list.txt")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lines {
+		if _, err := f.WriteString(l + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	m := NewMatcher()
+	if err := m.Load([]string{path}); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestHostnameAnchoredRule(t *testing.T) {
+	m := matcherFromLines(t, "||ads.example.com^")
+
+	blocked := m.Match(Request{URL: "https://ads.example.com/banner.js", ResourceType: "script", PageOrigin: "https://site.test"})
+	if !blocked {
+		t.Error("expected exact hostname match to block")
+	}
+
+	// Subdomains of the blocked domain should also match.
+	blocked = m.Match(Request{URL: "https://tracker.ads.example.com/x.js", PageOrigin: "https://site.test"})
+	if !blocked {
+		t.Error("expected subdomain of blocked hostname to block")
+	}
+
+	allowed := m.Match(Request{URL: "https://example.com/x.js", PageOrigin: "https://site.test"})
+	if allowed {
+		t.Error("expected sibling hostname not to block")
+	}
+}
+
+func TestWildcardPattern(t *testing.T) {
+	m := matcherFromLines(t, "/ads/*.js")
+
+	if !m.Match(Request{URL: "https://cdn.example.com/ads/banner.js", PageOrigin: "https://site.test"}) {
+		t.Error("expected wildcard path pattern to match")
+	}
+	if m.Match(Request{URL: "https://cdn.example.com/content/banner.js", PageOrigin: "https://site.test"}) {
+		t.Error("expected non-matching path not to match")
+	}
+}
+
+func TestExceptionOverridesBlock(t *testing.T) {
+	m := matcherFromLines(t,
+		"||example.com^$script",
+		"@@||example.com/allowed.js",
+	)
+
+	if !m.Match(Request{URL: "https://example.com/bad.js", ResourceType: "script", PageOrigin: "https://site.test"}) {
+		t.Error("expected non-exempted script to block")
+	}
+	if m.Match(Request{URL: "https://example.com/allowed.js", ResourceType: "script", PageOrigin: "https://site.test"}) {
+		t.Error("expected exception rule to override the block")
+	}
+}
+
+func TestResourceTypeOption(t *testing.T) {
+	m := matcherFromLines(t, "||example.com^$script")
+
+	if !m.Match(Request{URL: "https://example.com/x", ResourceType: "script", PageOrigin: "https://site.test"}) {
+		t.Error("expected script resource type to match")
+	}
+	if m.Match(Request{URL: "https://example.com/x", ResourceType: "image", PageOrigin: "https://site.test"}) {
+		t.Error("expected non-script resource type not to match a $script rule")
+	}
+}
+
+func TestThirdPartyOption(t *testing.T) {
+	m := matcherFromLines(t, "||tracker.test^$third-party")
+
+	if !m.Match(Request{URL: "https://tracker.test/x.js", PageOrigin: "https://site.test"}) {
+		t.Error("expected cross-site request to match a third-party rule")
+	}
+	if m.Match(Request{URL: "https://tracker.test/x.js", PageOrigin: "https://tracker.test"}) {
+		t.Error("expected same-site request not to match a third-party rule")
+	}
+}
+
+func TestDomainOption(t *testing.T) {
+	m := matcherFromLines(t, "||ads.test^$domain=site.test|~other.test")
+
+	if !m.Match(Request{URL: "https://ads.test/x.js", PageOrigin: "https://site.test"}) {
+		t.Error("expected included domain=site.test to match")
+	}
+	if m.Match(Request{URL: "https://ads.test/x.js", PageOrigin: "https://other.test"}) {
+		t.Error("expected excluded domain=~other.test not to match")
+	}
+	if m.Match(Request{URL: "https://ads.test/x.js", PageOrigin: "https://elsewhere.test"}) {
+		t.Error("expected a page origin absent from domain= to not match")
+	}
+}
+
+func TestCommentAndElementHideLinesIgnored(t *testing.T) {
+	m := matcherFromLines(t,
+		"! this is a comment",
+		"[Adblock Plus 2.0]",
+		"example.com##.ad-banner",
+		"||ads.test^",
+	)
+
+	if !m.Match(Request{URL: "https://ads.test/x.js", PageOrigin: "https://site.test"}) {
+		t.Error("expected the one real network rule to still match")
+	}
+}
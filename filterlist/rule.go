@@ -0,0 +1,145 @@
+package filterlist
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// rule is one parsed line of an EasyList/EasyPrivacy-format filter list.
+// Element-hiding rules ("##", "#@#") are rejected by parseLine since this
+// package only matches network requests.
+type rule struct {
+	raw          string
+	exception    bool
+	hostnameOnly string         // set for "||domain^"-shaped rules with no further pattern; routes to the hostname trie
+	pattern      *regexp.Regexp // set for every other rule; routes to the bucketed index
+	bucket       string         // rightmost literal domain label in pattern, or "" if none (catch-all bucket)
+
+	resourceTypes map[string]bool // nil/empty means "any type"
+	thirdParty    *bool           // nil = don't care, true = third-party only, false = first-party only
+	domainInclude []string        // domain=foo.com|bar.com
+	domainExclude []string        // domain=~foo.com
+}
+
+var optionResourceTypes = map[string]bool{
+	"script": true, "image": true, "stylesheet": true, "object": true,
+	"xmlhttprequest": true, "subdocument": true, "font": true, "media": true,
+	"websocket": true, "ping": true, "other": true,
+}
+
+// parseLine parses one line of a filter list, returning (nil, nil) for
+// blank lines, comments ("!", "[Adblock...]"), and element-hiding rules
+// ("##", "#@#"), which this package ignores.
+func parseLine(line string) (*rule, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+		return nil, nil
+	}
+	if strings.Contains(line, "##") || strings.Contains(line, "#@#") {
+		return nil, nil
+	}
+
+	r := &rule{raw: line}
+
+	if strings.HasPrefix(line, "@@") {
+		r.exception = true
+		line = line[2:]
+	}
+
+	pattern := line
+	if idx := strings.Index(line, "$"); idx >= 0 {
+		pattern = line[:idx]
+		if err := r.parseOptions(line[idx+1:]); err != nil {
+			return nil, err
+		}
+	}
+	if pattern == "" {
+		return nil, fmt.Errorf("filterlist: empty pattern in rule %q", line)
+	}
+
+	r.compile(pattern)
+	return r, nil
+}
+
+func (r *rule) parseOptions(opts string) error {
+	for _, opt := range strings.Split(opts, ",") {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case opt == "third-party":
+			t := true
+			r.thirdParty = &t
+		case opt == "~third-party":
+			f := false
+			r.thirdParty = &f
+		case strings.HasPrefix(opt, "domain="):
+			for _, d := range strings.Split(opt[len("domain="):], "|") {
+				if strings.HasPrefix(d, "~") {
+					r.domainExclude = append(r.domainExclude, d[1:])
+				} else {
+					r.domainInclude = append(r.domainInclude, d)
+				}
+			}
+		case optionResourceTypes[opt]:
+			if r.resourceTypes == nil {
+				r.resourceTypes = map[string]bool{}
+			}
+			r.resourceTypes[opt] = true
+		default:
+			// Unrecognized options (match-case, popup, csp=..., rewrite=...,
+			// redirect=...) are accepted but not enforced: this matcher only
+			// needs to decide "is this script trusted", not fully replay
+			// EasyList's request-blocking semantics.
+		}
+	}
+	return nil
+}
+
+// compile turns pattern into either a hostnameOnly fast-path entry (for a
+// bare "||domain^" with nothing else) or a bucketed regex, extracting the
+// rightmost literal domain label to bucket it by so Matcher doesn't have to
+// test every pattern rule against every request.
+func (r *rule) compile(pattern string) {
+	if strings.HasPrefix(pattern, "||") {
+		rest := pattern[2:]
+		end := strings.IndexAny(rest, "^/*")
+		domain := rest
+		if end >= 0 {
+			domain = rest[:end]
+		}
+		if domain != "" && (end < 0 || rest[end:] == "^" || rest[end:] == "") {
+			r.hostnameOnly = domain
+			r.bucket = rightmostLabel(domain)
+			return
+		}
+	}
+
+	r.pattern = compileGlob(pattern)
+	r.bucket = rightmostLabel(domainLikeHint(pattern))
+}
+
+// rightmostLabel returns the last dot-delimited label of host, or "" if
+// host has none (e.g. it's a glob with no literal domain to key off of).
+func rightmostLabel(host string) string {
+	host = strings.TrimSuffix(host, "^")
+	labels := strings.Split(host, ".")
+	last := labels[len(labels)-1]
+	if last == "" || strings.ContainsAny(last, "*^|$") {
+		return ""
+	}
+	return strings.ToLower(last)
+}
+
+// domainLikeHint strips anchors and options-looking suffixes off pattern to
+// guess at the hostname portion a "||"-less rule still usually starts with,
+// e.g. "|http://ads.example.com/track" or "ads.example.com/track.js".
+func domainLikeHint(pattern string) string {
+	p := strings.TrimPrefix(pattern, "|")
+	if idx := strings.Index(p, "://"); idx >= 0 {
+		p = p[idx+3:]
+	}
+	if idx := strings.IndexAny(p, "/^*"); idx >= 0 {
+		p = p[:idx]
+	}
+	return p
+}
@@ -0,0 +1,84 @@
+package main
+
+import "strings"
+
+// sandboxTokens are the iframe sandbox="..." tokens AnalyzePolicy reasons
+// about; any other token (allow-modals, allow-pointer-lock, etc.) is
+// ignored rather than rejected, the same way a browser ignores tokens it
+// doesn't recognize.
+var sandboxTokens = map[string]bool{
+	"allow-scripts":        true,
+	"allow-same-origin":    true,
+	"allow-forms":          true,
+	"allow-popups":         true,
+	"allow-top-navigation": true,
+}
+
+// FrameNode is one node in a page's frame tree, built up from
+// Page.frameAttached/frameNavigated (see policy.go): its id, its parent's id
+// (empty for the top-level frame), and the sandbox tokens declared on its
+// owning <iframe sandbox="...">, if any. A frame without a sandbox
+// attribute declares no restrictions of its own, though Allows still
+// applies whatever restrictions its ancestors declare.
+//
+// Per-frame trust is deliberately not modeled here beyond sandbox tokens:
+// Policy (trust_group.go) is still one flat, per-page set of TrustGroups,
+// and nothing maps Debugger.scriptParsed's executionContextId to a frame,
+// so there's no way to attribute a script to the frame it ran in rather
+// than to the page as a whole. Giving each frame its own inherited
+// TrustGroup would need that attribution built first; until then a
+// snapshot here would just be dead data nothing reads.
+type FrameNode struct {
+	FrameId       string
+	ParentFrameId string
+	TargetId      string
+	Sandboxed     bool
+	SandboxFlags  map[string]bool
+}
+
+// Allows reports whether this frame is permitted to perform an action
+// gated by sandbox token flag, climbing frames (via ParentFrameId, looked
+// up in frames) up to the top-level frame. Per the HTML sandboxing model,
+// restrictions only ever compound going down the tree: a nested frame
+// without a sandbox="..." of its own isn't thereby unrestricted, it
+// inherits every restriction its ancestors have, and a token it declares
+// itself (e.g. allow-forms) can't hand back a permission an ancestor
+// didn't grant. So this returns false if flag is missing from ANY
+// sandboxed frame on the path from f to the root, not just from f itself.
+// An unknown frame (nil, or not yet reported by Page.frameAttached) allows
+// everything, the same as before frame-scoped policy existed.
+func (f *FrameNode) Allows(frames map[string]*FrameNode, flag string) bool {
+	seen := map[string]bool{}
+	for cur := f; cur != nil && !seen[cur.FrameId]; cur = frames[cur.ParentFrameId] {
+		seen[cur.FrameId] = true
+		if cur.Sandboxed && !cur.SandboxFlags[flag] {
+			return false
+		}
+	}
+	return true
+}
+
+func parseSandboxAttr(attr string) map[string]bool {
+	flags := map[string]bool{}
+	for _, tok := range strings.Fields(attr) {
+		if sandboxTokens[tok] {
+			flags[tok] = true
+		}
+	}
+	return flags
+}
+
+func (cc *ChromeClient) setFrameNode(f *FrameNode) {
+	cc.Lock()
+	defer cc.Unlock()
+	cc.frames[f.FrameId] = f
+}
+
+// FrameNode returns the tracked frame node for frameId, or nil if the page
+// hasn't reported it yet (e.g. a frame this run's -policy doesn't track
+// sandboxing for, or a run that predates this feature's replay log).
+func (cc *ChromeClient) FrameNode(frameId string) *FrameNode {
+	cc.Lock()
+	defer cc.Unlock()
+	return cc.frames[frameId]
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path"
+	"time"
+)
+
+// HAR types follow the HAR 1.2 spec (http://www.softwareishard.com/blog/har-12-spec/).
+// Only the fields this tool can actually populate are included; everything
+// else is left at its zero value, which HAR consumers treat as "unknown".
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Pages   []HARPage  `json:"pages"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HARPage struct {
+	StartedDateTime time.Time     `json:"startedDateTime"`
+	Id              string        `json:"id"`
+	Title           string        `json:"title"`
+	PageTimings     HARPageTiming `json:"pageTimings"`
+}
+
+type HARPageTiming struct {
+	OnLoad int `json:"onLoad"`
+}
+
+type HAREntry struct {
+	Pageref         string      `json:"pageref"`
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            int         `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           HARCache    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Policy          *PolicyInfo `json:"_policy"`
+}
+
+type HARRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []HARHeader `json:"headers"`
+	QueryString []HARParam  `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []HARHeader `json:"headers"`
+	Content     HARContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type HARHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARParam struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCache struct{}
+
+type HARTimings struct {
+	Blocked int `json:"blocked"`
+	Send    int `json:"send"`
+	Wait    int `json:"wait"`
+	Receive int `json:"receive"`
+}
+
+// PolicyInfo is the custom "_policy" field HAR viewers ignore but this
+// tool's own tooling can read back out, carrying the trust verdict that
+// produced a given request alongside the entry it came from.
+type PolicyInfo struct {
+	PolicyId    string       `json:"policyId"`
+	Trusted     bool         `json:"trusted"`
+	PolicyState *PolicyState `json:"policyState"`
+}
+
+func harHeaders(h map[string]string) []HARHeader {
+	ret := []HARHeader{}
+	for name, value := range h {
+		ret = append(ret, HARHeader{Name: name, Value: value})
+	}
+	return ret
+}
+
+// GenerateHAR builds a HAR 1.2 log for policyId out of the accumulated
+// NetworkRequestWillBeSentEvent/NetworkResponseReceivedEvent/
+// LoadingFinishedEvent/PageLifecycleEvent log, correlating all four by
+// RequestId (or FrameId, for pages).
+func (cc *ChromeClient) GenerateHAR(policyId string) *HARLog {
+	requests := cc.NetworkRequestWillBeSentLogs(policyId)
+	responses := map[string]*NetworkResponseReceivedEvent{}
+	for _, r := range cc.NetworkResponseReceivedLogs(policyId) {
+		responses[r.RequestId] = r
+	}
+	finished := map[string]*LoadingFinishedEvent{}
+	for _, f := range cc.LoadingFinishedLogs(policyId) {
+		finished[f.RequestId] = f
+	}
+
+	har := &HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "web-exfiltration", Version: "1.0"},
+		Pages:   []HARPage{},
+		Entries: []HAREntry{},
+	}
+
+	for _, p := range cc.PageLifecycleEventLogs(policyId) {
+		if p.Name != "load" {
+			continue
+		}
+		har.Pages = append(har.Pages, HARPage{
+			StartedDateTime: p.Timestamp,
+			Id:              p.FrameId,
+			Title:           p.FrameId,
+			PageTimings:     HARPageTiming{OnLoad: 0},
+		})
+	}
+
+	for requestId, req := range requestsById(requests) {
+		resp := responses[requestId]
+		fin := finished[requestId]
+
+		entry := HAREntry{
+			Pageref:         req.FrameId,
+			StartedDateTime: req.Timestamp,
+			Time:            0,
+			Request: HARRequest{
+				Method:      req.Request.Method,
+				URL:         req.Request.URL,
+				HTTPVersion: "HTTP/1.1",
+				Headers:     []HARHeader{},
+				QueryString: []HARParam{},
+			},
+			Cache: HARCache{},
+			Policy: &PolicyInfo{
+				PolicyId:    req.PolicyId,
+				Trusted:     req.PolicyState.StackIsTrusted(scriptIdStackOf(req.Initiator)),
+				PolicyState: req.PolicyState,
+			},
+		}
+
+		if resp != nil {
+			entry.Response = HARResponse{
+				Status:      resp.Response.Status,
+				StatusText:  resp.Response.StatusText,
+				HTTPVersion: "HTTP/1.1",
+				Headers:     harHeaders(resp.Response.Headers),
+				Content: HARContent{
+					MimeType: resp.Response.MimeType,
+				},
+			}
+		}
+		if fin != nil {
+			entry.Response.Content.Size = int(fin.EncodedDataLength)
+			entry.Response.BodySize = int(fin.EncodedDataLength)
+			entry.Time = int(fin.Timestamp.Sub(req.Timestamp).Milliseconds())
+		}
+
+		har.Entries = append(har.Entries, entry)
+	}
+
+	return har
+}
+
+func requestsById(events []*NetworkRequestWillBeSentEvent) map[string]*NetworkRequestWillBeSentEvent {
+	ret := map[string]*NetworkRequestWillBeSentEvent{}
+	for _, e := range events {
+		ret[e.RequestId] = e
+	}
+	return ret
+}
+
+func scriptIdStackOf(initiator *Initiator) []string {
+	scriptIds := []string{}
+	if initiator == nil || initiator.StackTrace == nil {
+		return scriptIds
+	}
+	callFrames := initiator.StackTrace.CallFrames
+	if len(callFrames) == 0 && initiator.StackTrace.Parent != nil {
+		callFrames = initiator.StackTrace.Parent.CallFrames
+	}
+	for _, cf := range callFrames {
+		scriptIds = append(scriptIds, cf.ScriptId)
+	}
+	return scriptIds
+}
+
+// GenerateMergedHAR builds a single HAR 1.2 log covering every policy id
+// the run has seen (navReset mints a new one on every navigation, so a
+// run with more than one navigation has more than one), the way
+// WriteCSPReportsToFile merges Violations across policies. A HAR 1.2
+// document has exactly one top-level log object; nesting per-policy logs
+// under it, as a single run's events.har used to, isn't valid HAR for any
+// consumer (Chrome DevTools, Fiddler, har-analyzer, ...) expecting that.
+func (cc *ChromeClient) GenerateMergedHAR() *HARLog {
+	merged := &HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "web-exfiltration", Version: "1.0"},
+		Pages:   []HARPage{},
+		Entries: []HAREntry{},
+	}
+	for _, policyId := range cc.policyIds {
+		h := cc.GenerateHAR(policyId)
+		merged.Pages = append(merged.Pages, h.Pages...)
+		merged.Entries = append(merged.Entries, h.Entries...)
+	}
+	return merged
+}
+
+// WriteHARToFile writes the merged HAR for every observed policy to
+// events.har next to events.json in the run's logs directory.
+func (cc *ChromeClient) WriteHARToFile() {
+	har := cc.GenerateMergedHAR()
+
+	b, err := json.MarshalIndent(map[string]interface{}{"log": har}, "", "  ")
+	if err != nil {
+		log.Panic("error marshalling HAR")
+	}
+	err = ioutil.WriteFile(path.Join(cc.logsDir, "events.har"), b, 0644)
+	if err != nil {
+		log.Panic("error writing HAR")
+	}
+}
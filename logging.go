@@ -30,6 +30,7 @@ type NetworkRequestWillBeSentEvent struct {
 	PolicyId  string
 	TargetId  string
 
+	RequestId      string
 	Initiator      *Initiator
 	LoaderId       string
 	FrameId        string
@@ -40,6 +41,63 @@ type NetworkRequestWillBeSentEvent struct {
 	PolicyState    *PolicyState
 }
 
+type NetworkResponseReceivedEvent struct {
+	EventId   int
+	Timestamp time.Time
+	EventType string
+	PolicyId  string
+	TargetId  string
+
+	RequestId    string
+	LoaderId     string
+	FrameId      string
+	ResourceType string
+	Response     *NetworkResponse
+	PolicyState  *PolicyState
+}
+
+type LoadingFinishedEvent struct {
+	EventId   int
+	Timestamp time.Time
+	EventType string
+	PolicyId  string
+	TargetId  string
+
+	RequestId         string
+	EncodedDataLength float64
+}
+
+type WebSocketFrameSentEvent struct {
+	EventId   int
+	Timestamp time.Time
+	EventType string
+	PolicyId  string
+	TargetId  string
+
+	RequestId   string
+	URL         string
+	Initiator   *Initiator
+	Opcode      int
+	PayloadData string
+	PolicyState *PolicyState
+}
+
+type NetworkRequestBlockedEvent struct {
+	EventId   int
+	Timestamp time.Time
+	EventType string
+	PolicyId  string
+	TargetId  string
+
+	RequestId      string
+	URL            string
+	ResourceType   string
+	Reason         string
+	MatchedPattern string
+	ScriptIdStack  []string
+	PolicyState    *PolicyState
+}
+
 type APIAccessEvent struct {
 	EventId   int
 	Timestamp time.Time
@@ -48,6 +106,7 @@ type APIAccessEvent struct {
 	TargetId  string
 
 	APIName       string
+	Argument      string
 	ScriptIdStack []string
 	PolicyState   *PolicyState
 }
@@ -78,6 +137,22 @@ type PageLifecycleEvent struct {
 	PolicyState *PolicyState
 }
 
+// IntegrityMismatchEvent is logged when checkSubresourceIntegrity (policy.go)
+// re-fetches and re-hashes a <script integrity="..."> and finds the body no
+// longer matches its declared digest, independently of the browser's own SRI
+// enforcement.
+type IntegrityMismatchEvent struct {
+	EventId   int
+	Timestamp time.Time
+	EventType string
+	PolicyId  string
+	TargetId  string
+
+	URL         string
+	Integrity   string
+	PolicyState *PolicyState
+}
+
 // Structs used in events.
 type NetworkRequest struct {
 	URL           string
@@ -95,6 +170,40 @@ func NewNetworkRequest(p Message) *NetworkRequest {
 	}
 }
 
+type NetworkResponse struct {
+	URL               string
+	Status            int
+	StatusText        string
+	MimeType          string
+	Headers           map[string]string
+	RequestHeaders    map[string]string
+	FromDiskCache     bool
+	EncodedDataLength float64
+}
+
+func NewNetworkResponse(p Message) *NetworkResponse {
+	return &NetworkResponse{
+		URL:               p.String("url"),
+		Status:            p.Int("status"),
+		StatusText:        p.String("statusText"),
+		MimeType:          p.String("mimeType"),
+		Headers:           headersOf(p.Message("headers")),
+		RequestHeaders:    headersOf(p.Message("requestHeaders")),
+		FromDiskCache:     p.Bool("fromDiskCache"),
+		EncodedDataLength: float64(p.Int("encodedDataLength")),
+	}
+}
+
+func headersOf(m Message) map[string]string {
+	h := map[string]string{}
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			h[k] = s
+		}
+	}
+	return h
+}
+
 type Initiator struct {
 	Type       string
 	StackTrace *StackTrace
@@ -202,6 +311,135 @@ func (s *TrustGroupState) GetInlineScript(scriptId string) *InlineScript {
 	return nil
 }
 
+// eventSubscriber is a live watcher registered via
+// ChromeClient.SubscribeFrom, fed by LogEvent as events are appended.
+// Ch is buffered and drop-oldest: a slow subscriber loses its oldest
+// unread events rather than blocking the goroutine that's logging them.
+type eventSubscriber struct {
+	policyId string
+	ch       chan interface{}
+}
+
+const eventSubscriberBuffer = 64
+
+func eventEventId(e interface{}) int {
+	switch v := e.(type) {
+	case *NetworkRequestInterceptedEvent:
+		return v.EventId
+	case *NetworkRequestWillBeSentEvent:
+		return v.EventId
+	case *NetworkResponseReceivedEvent:
+		return v.EventId
+	case *LoadingFinishedEvent:
+		return v.EventId
+	case *WebSocketFrameSentEvent:
+		return v.EventId
+	case *NetworkRequestBlockedEvent:
+		return v.EventId
+	case *APIAccessEvent:
+		return v.EventId
+	case *DebuggerScriptParsedEvent:
+		return v.EventId
+	case *PageLifecycleEvent:
+		return v.EventId
+	case *IntegrityMismatchEvent:
+		return v.EventId
+	default:
+		return 0
+	}
+}
+
+func eventType(e interface{}) string {
+	switch v := e.(type) {
+	case *NetworkRequestInterceptedEvent:
+		return v.EventType
+	case *NetworkRequestWillBeSentEvent:
+		return v.EventType
+	case *NetworkResponseReceivedEvent:
+		return v.EventType
+	case *LoadingFinishedEvent:
+		return v.EventType
+	case *WebSocketFrameSentEvent:
+		return v.EventType
+	case *NetworkRequestBlockedEvent:
+		return v.EventType
+	case *APIAccessEvent:
+		return v.EventType
+	case *DebuggerScriptParsedEvent:
+		return v.EventType
+	case *PageLifecycleEvent:
+		return v.EventType
+	case *IntegrityMismatchEvent:
+		return v.EventType
+	default:
+		return ""
+	}
+}
+
+func eventPolicyId(e interface{}) string {
+	switch v := e.(type) {
+	case *NetworkRequestInterceptedEvent:
+		return v.PolicyId
+	case *NetworkRequestWillBeSentEvent:
+		return v.PolicyId
+	case *NetworkResponseReceivedEvent:
+		return v.PolicyId
+	case *LoadingFinishedEvent:
+		return v.PolicyId
+	case *WebSocketFrameSentEvent:
+		return v.PolicyId
+	case *NetworkRequestBlockedEvent:
+		return v.PolicyId
+	case *APIAccessEvent:
+		return v.PolicyId
+	case *DebuggerScriptParsedEvent:
+		return v.PolicyId
+	case *PageLifecycleEvent:
+		return v.PolicyId
+	case *IntegrityMismatchEvent:
+		return v.PolicyId
+	default:
+		return ""
+	}
+}
+
+// SubscribeFrom registers a new eventSubscriber for policyId (all policies
+// if empty) and atomically returns it alongside every already-logged event
+// after lastEventId, so a reconnecting client can replay what it missed
+// without racing newly-logged events.
+func (cc *ChromeClient) SubscribeFrom(policyId string, lastEventId int) (*eventSubscriber, []interface{}) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	replay := []interface{}{}
+	for _, e := range cc.eventLog {
+		if eventEventId(e) <= lastEventId {
+			continue
+		}
+		if policyId != "" && eventPolicyId(e) != policyId {
+			continue
+		}
+		replay = append(replay, e)
+	}
+
+	sub := &eventSubscriber{policyId: policyId, ch: make(chan interface{}, eventSubscriberBuffer)}
+	cc.subscribers = append(cc.subscribers, sub)
+
+	return sub, replay
+}
+
+func (cc *ChromeClient) Unsubscribe(sub *eventSubscriber) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	for i, s := range cc.subscribers {
+		if s == sub {
+			cc.subscribers = append(cc.subscribers[:i], cc.subscribers[i+1:]...)
+			break
+		}
+	}
+}
+
 func (cc *ChromeClient) LogEvent(e interface{}) {
 	cc.Lock()
 	defer cc.Unlock()
@@ -211,18 +449,49 @@ func (cc *ChromeClient) LogEvent(e interface{}) {
 		v.EventId = cc.nextEventId
 	case *NetworkRequestWillBeSentEvent:
 		v.EventId = cc.nextEventId
+	case *NetworkResponseReceivedEvent:
+		v.EventId = cc.nextEventId
+	case *LoadingFinishedEvent:
+		v.EventId = cc.nextEventId
+	case *WebSocketFrameSentEvent:
+		v.EventId = cc.nextEventId
+	case *NetworkRequestBlockedEvent:
+		v.EventId = cc.nextEventId
 	case *APIAccessEvent:
 		v.EventId = cc.nextEventId
 	case *DebuggerScriptParsedEvent:
 		v.EventId = cc.nextEventId
 	case *PageLifecycleEvent:
 		v.EventId = cc.nextEventId
+	case *IntegrityMismatchEvent:
+		v.EventId = cc.nextEventId
 	default:
 		log.Panic("bad log event type", v)
 	}
 
 	cc.eventLog = append(cc.eventLog, e)
 	cc.nextEventId += 1
+
+	cc.metrics.eventsTotal.WithLabelValues(eventType(e), eventPolicyId(e)).Inc()
+
+	for _, sub := range cc.subscribers {
+		if sub.policyId != "" && eventPolicyId(e) != sub.policyId {
+			continue
+		}
+
+		select {
+		case sub.ch <- e:
+		default:
+			select {
+			case <-sub.ch:
+			default:
+			}
+			select {
+			case sub.ch <- e:
+			default:
+			}
+		}
+	}
 }
 
 func (cc *ChromeClient) WriteLogToFile() {
@@ -260,6 +529,54 @@ func (cc *ChromeClient) NetworkRequestWillBeSentLogs(policyId string) []*Network
 	return ret
 }
 
+func (cc *ChromeClient) NetworkResponseReceivedLogs(policyId string) []*NetworkResponseReceivedEvent {
+	ret := []*NetworkResponseReceivedEvent{}
+
+	for _, e := range cc.eventLog {
+		if c, ok := e.(*NetworkResponseReceivedEvent); ok && c.PolicyId == policyId {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret
+}
+
+func (cc *ChromeClient) LoadingFinishedLogs(policyId string) []*LoadingFinishedEvent {
+	ret := []*LoadingFinishedEvent{}
+
+	for _, e := range cc.eventLog {
+		if c, ok := e.(*LoadingFinishedEvent); ok && c.PolicyId == policyId {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret
+}
+
+func (cc *ChromeClient) WebSocketFrameSentLogs(policyId string) []*WebSocketFrameSentEvent {
+	ret := []*WebSocketFrameSentEvent{}
+
+	for _, e := range cc.eventLog {
+		if c, ok := e.(*WebSocketFrameSentEvent); ok && c.PolicyId == policyId {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret
+}
+
+func (cc *ChromeClient) NetworkRequestBlockedLogs(policyId string) []*NetworkRequestBlockedEvent {
+	ret := []*NetworkRequestBlockedEvent{}
+
+	for _, e := range cc.eventLog {
+		if c, ok := e.(*NetworkRequestBlockedEvent); ok && c.PolicyId == policyId {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret
+}
+
 func (cc *ChromeClient) APIAccessLogs(policyId string) []*APIAccessEvent {
 	ret := []*APIAccessEvent{}
 
@@ -295,3 +612,15 @@ func (cc *ChromeClient) PageLifecycleEventLogs(policyId string) []*PageLifecycle
 
 	return ret
 }
+
+func (cc *ChromeClient) IntegrityMismatchLogs(policyId string) []*IntegrityMismatchEvent {
+	ret := []*IntegrityMismatchEvent{}
+
+	for _, e := range cc.eventLog {
+		if c, ok := e.(*IntegrityMismatchEvent); ok && c.PolicyId == policyId {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret
+}
@@ -1,18 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"crypto/md5"
 	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path"
 	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -27,11 +31,29 @@ var chromiumLog bool
 var verbose bool
 var headless bool
 var policyType int
+var hostnameMatchMode int
 var openDevTools bool
 var openURL string
 var runDir string
 var uploadResults bool
 var s3Bucket string
+var resultsSinkURL string
+var urlListPath string
+var cspOutPath string
+var hostnameMatch string
+var policyStorePath string
+var enforceMode bool
+var harOut bool
+var batchMode bool
+var concurrency int
+var urlListFormat string
+var urlTimeout time.Duration
+var controlMode bool
+var grpcAddr string
+var filterListsFlag string
+var filterListRefresh time.Duration
+var cspPolicyPath string
+var cspReportOnly bool
 
 func init() {
 	flag.StringVar(&chromiumPath, "chromium", defaultChromiumPath(), "path to Chromium binary")
@@ -41,16 +63,51 @@ func init() {
 	flag.BoolVar(&verbose, "verbose", false, "verbose output")
 	flag.BoolVar(&headless, "headless", false, "run headless Chromium")
 	flag.IntVar(&policyType, "policy", policyTypeTrusting, "run headless Chromium")
+	flag.StringVar(&hostnameMatch, "hostname-match", "exact", "hostname match mode for -policy=2 (hostname): exact, subdomain, or etld+1")
+	flag.StringVar(&policyStorePath, "policy-store", "policy_store.json", "file to persist the learned allowlist for -policy=4 (learned) across runs")
 	flag.BoolVar(&openDevTools, "open-devtools", false, "auto-open dev tools for new tabs")
 	flag.StringVar(&openURL, "open-url", "", "URL to open")
 	flag.StringVar(&runDir, "run-dir", "run", "directory to output run data")
-	flag.BoolVar(&uploadResults, "upload-results", false, "upload results to S3")
-	flag.StringVar(&s3Bucket, "s3-bucket", "eal5ub-exfiltration-study", "S3 bucket to download chromium from & upload results to")
+	flag.BoolVar(&uploadResults, "upload-results", false, "upload results to -s3-bucket; deprecated, equivalent to -results-sink=s3://<s3-bucket>")
+	flag.StringVar(&s3Bucket, "s3-bucket", "eal5ub-exfiltration-study", "S3 bucket to download chromium from, and to upload results to when -upload-results is set without -results-sink")
+	flag.StringVar(&resultsSinkURL, "results-sink", "", "where to upload run results: s3://bucket/prefix, gs://bucket/prefix, azblob://account/container/prefix, https://host/path, or file:///local/dir")
+	flag.BoolVar(&resultsSinkStream, "results-sink-stream", false, "upload each per-namespace log file to -results-sink as it's written, instead of tarring the whole run dir at the end")
+	flag.StringVar(&urlListPath, "url-list", "", "file of URLs (one per line) to crawl and generate a learned CSP for, instead of -open-url")
+	flag.StringVar(&cspOutPath, "csp-out", "csp.txt", "file to write per-origin Content-Security-Policy headers to (used with -url-list)")
+	flag.BoolVar(&enforceMode, "enforce", false, "actively block untrusted requests via the Fetch domain, instead of only logging them")
+	flag.BoolVar(&harOut, "har-out", false, "also write the run's event log as a HAR 1.2 capture (events.har) alongside events.json")
+	flag.BoolVar(&batchMode, "batch", false, "treat -url-list as a batch crawl: spread its URLs across -concurrency isolated Chromium processes instead of generating a CSP")
+	flag.IntVar(&concurrency, "concurrency", 4, "number of Chromium processes to run concurrently in -batch mode")
+	flag.StringVar(&urlListFormat, "url-list-format", "plain", "-url-list format in -batch mode: plain (one URL per line), csv (URL in the first column), or jsonl (one {\"url\": \"...\"} object per line)")
+	flag.DurationVar(&urlTimeout, "url-timeout", 0, "in -batch mode, abandon a URL and respawn a fresh Chromium process if it hasn't finished loading after this long; 0 disables the timeout")
+	flag.BoolVar(&controlMode, "control", false, "run a controlpb.ExfilRunner gRPC control plane on -grpc-addr instead of driving a single run or batch directly")
+	flag.StringVar(&grpcAddr, "grpc-addr", ":50051", "address to serve the controlpb.ExfilRunner gRPC service on, in -control mode")
+	flag.StringVar(&filterListsFlag, "filter-lists", "filter_lists/easylist.txt,filter_lists/easyprivacy.txt", "comma-separated EasyList/EasyPrivacy-format filter lists for -policy=3 (filterlist)")
+	flag.DurationVar(&filterListRefresh, "filter-lists-refresh", 10*time.Minute, "how often to reload -filter-lists from disk; 0 disables periodic refresh")
+	flag.StringVar(&cspPolicyPath, "csp-policy", "", "path to a CSP-directive-style policy config (directives separated by ';', e.g. \"default-src 'self'; connect-src 'none'\") to evaluate this run's requests against")
+	flag.BoolVar(&cspReportOnly, "csp-report-only", true, "log -csp-policy violations without failing the run, like Content-Security-Policy-Report-Only")
 	flag.Parse()
 
 	if policyType >= policyTypeInvalid {
 		log.Fatalf("bad policy flag: expected integer [0, %v]", policyTypeInvalid-1)
 	}
+
+	switch hostnameMatch {
+	case "exact":
+		hostnameMatchMode = hostnameMatchExact
+	case "subdomain":
+		hostnameMatchMode = hostnameMatchSubdomain
+	case "etld+1":
+		hostnameMatchMode = hostnameMatchETLDPlusOne
+	default:
+		log.Fatalf("bad hostname-match flag: expected exact, subdomain, or etld+1")
+	}
+
+	switch urlListFormat {
+	case "plain", "csv", "jsonl":
+	default:
+		log.Fatalf("bad url-list-format flag: expected plain, csv, or jsonl")
+	}
 }
 
 func downloadChromium(awsSess *session.Session) {
@@ -86,35 +143,17 @@ func downloadChromium(awsSess *session.Session) {
 	}
 }
 
-func uploadResultsToS3(awsSess *session.Session, runDir string, namespace string) {
-	tarFileName := fmt.Sprintf("%v.tar.gz", namespace)
-
-	log.Println("Compressing run dir...")
-	cmd := exec.Command("tar", "-czf", tarFileName, runDir)
-	if err := cmd.Run(); err != nil {
-		log.Fatal(err)
-	}
-
-	f, err := os.Open(tarFileName)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-
-	log.Println("Uploading run dir...")
-	uploader := s3manager.NewUploader(awsSess)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(s3Bucket),
-		Key:    aws.String(path.Join(runDir, tarFileName)),
-		Body:   f,
-	})
-	if err != nil {
-		log.Fatal(err)
+// resolveResultsSinkURL returns the -results-sink URL to use, falling back
+// to the deprecated -upload-results/-s3-bucket pair if -results-sink wasn't
+// set, so existing invocations keep uploading where they always did.
+func resolveResultsSinkURL() string {
+	if resultsSinkURL != "" {
+		return resultsSinkURL
 	}
-
-	if err := os.Remove(tarFileName); err != nil {
-		log.Fatal(err)
+	if uploadResults {
+		return fmt.Sprintf("s3://%v", s3Bucket)
 	}
+	return ""
 }
 
 func defaultChromiumPath() string {
@@ -127,18 +166,17 @@ func defaultChromiumPath() string {
 	return ""
 }
 
-func main() {
-	log.SetPrefix("> ")
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-
+// runURL drives a single Chromium run against targetURL and returns the
+// ChromeClient once the run has stopped and its logs have been written.
+func runURL(targetURL string) *ChromeClient {
 	namespace := "default"
-	if openURL != "" {
+	if targetURL != "" {
 		h := md5.New()
-		io.WriteString(h, openURL)
+		io.WriteString(h, targetURL)
 		namespace = hex.EncodeToString(h.Sum(nil))
 	}
 	log.Println("Using namespace:", namespace)
-	log.Println("URL:", openURL)
+	log.Println("URL:", targetURL)
 	rd := path.Join(runDir, namespace)
 
 	os.MkdirAll(path.Join(rd, "logs"), os.ModePerm)
@@ -152,13 +190,7 @@ func main() {
 	logOut := io.MultiWriter(os.Stdout, f)
 	log.SetOutput(logOut)
 
-	awsSess := session.Must(session.NewSessionWithOptions(session.Options{
-		Config:  aws.Config{Region: aws.String("us-east-1")},
-		Profile: "exfiltration-study",
-	}))
-	downloadChromium(awsSess)
-
-	cc := NewChromeClient(rd, policyType)
+	cc := NewChromeClient(rd, policyType, remoteDebuggingPort, webServerPort)
 
 	go func() {
 		c := make(chan os.Signal)
@@ -173,8 +205,8 @@ func main() {
 
 	cc.WaitReady()
 
-	if openURL != "" {
-		cc.OpenURL(openURL)
+	if targetURL != "" {
+		cc.OpenURL(targetURL)
 	}
 
 	if err := cc.WaitStopped(); err != nil {
@@ -183,9 +215,93 @@ func main() {
 	}
 
 	cc.WriteLogToFile()
+	if harOut {
+		cc.WriteHARToFile()
+	}
+	if policy := loadConfiguredCSPPolicy(); policy != nil {
+		if cc.WriteCSPReportsToFile(policy, cspReportOnly) && !cspReportOnly {
+			log.Println("CSP policy violated, failing run")
+			os.Exit(1)
+		}
+	}
 
-	if uploadResults {
-		uploadResultsToS3(awsSess, rd, namespace)
+	uploadRunResults(rd, namespace)
+
+	return cc
+}
+
+// runURLListCSP crawls every URL in urlListPath and appends the learned CSP
+// for its origin to cspOutPath, so a crawler-produced URL list can be turned
+// directly into headers for deployment.
+func runURLListCSP(urlListPath string, cspOutPath string) {
+	in, err := os.Open(urlListPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(cspOutPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		u := strings.TrimSpace(s.Text())
+		if u == "" {
+			continue
+		}
+
+		cc := runURL(u)
+		t := cc.getTarget(u)
+		if t == nil {
+			log.Println("No target recorded for", u)
+			continue
+		}
+
+		parsed, err := url.Parse(u)
+		if err != nil {
+			log.Println("Bad URL, skipping CSP:", u)
+			continue
+		}
+		origin := fmt.Sprintf("%v://%v", parsed.Scheme, parsed.Host)
+
+		fmt.Fprintf(out, "# %v\nContent-Security-Policy: %v\n\n", origin, t.Policy.GenerateCSP())
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Run complete")
+}
+
+func main() {
+	log.SetPrefix("> ")
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	awsSess := session.Must(session.NewSessionWithOptions(session.Options{
+		Config:  aws.Config{Region: aws.String("us-east-1")},
+		Profile: "exfiltration-study",
+	}))
+	downloadChromium(awsSess)
+	resultsSink = newResultsSink(awsSess, resolveResultsSinkURL())
+
+	if controlMode {
+		runControlServer()
+		return
 	}
+
+	if urlListPath != "" && batchMode {
+		runBatch(urlListPath, concurrency)
+		return
+	}
+
+	if urlListPath != "" {
+		runURLListCSP(urlListPath, cspOutPath)
+		return
+	}
+
+	runURL(openURL)
 	log.Println("Run complete")
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics holds the Prometheus collectors exposed on /metrics, scoped to a
+// single ChromeClient's own registry so concurrent batch-mode workers don't
+// collide on process-global metric state.
+type metrics struct {
+	eventsTotal     *prometheus.CounterVec
+	requestsBlocked *prometheus.CounterVec
+	apiAccessTotal  *prometheus.CounterVec
+	activeTargets   prometheus.Gauge
+	pageLoadSeconds *prometheus.HistogramVec
+}
+
+func newMetrics(reg *prometheus.Registry) *metrics {
+	m := &metrics{
+		eventsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webexfil_events_total",
+			Help: "Total instrumentation events logged, by event type and policy.",
+		}, []string{"type", "policy_id"}),
+		requestsBlocked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webexfil_requests_blocked_total",
+			Help: "Total requests blocked by the Fetch enforcement feature, by reason and policy.",
+		}, []string{"reason", "policy_id"}),
+		apiAccessTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webexfil_api_access_total",
+			Help: "Total sensitive API accesses observed, by API name and whether the calling script was trusted.",
+		}, []string{"api", "trusted"}),
+		activeTargets: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "webexfil_active_targets",
+			Help: "Number of Chrome targets (tabs) currently tracked by this process.",
+		}),
+		pageLoadSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "webexfil_page_load_seconds",
+			Help:    "Time from Target.createTarget (or the prior URL's navigation, in batch mode) to the load PageLifecycleEvent, by policy.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"policy_id"}),
+	}
+
+	reg.MustRegister(m.eventsTotal, m.requestsBlocked, m.apiAccessTotal, m.activeTargets, m.pageLoadSeconds)
+	return m
+}
@@ -5,10 +5,13 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
@@ -22,6 +25,7 @@ const (
 	policyTypeUntrusting
 	policyTypeHostname
 	policyTypeFilterList
+	policyTypeLearned
 	policyTypeInvalid
 )
 
@@ -30,6 +34,10 @@ type Policy struct {
 	// classified in the first trustGroup that accepts it.
 	Id          string
 	trustGroups []*TrustGroup
+
+	// LearnedOrigin is set by NewLearnedPolicy and names the origin this
+	// policy's observations should be committed back to in the PolicyStore.
+	LearnedOrigin string
 }
 
 func newPolicyId() string {
@@ -43,11 +51,13 @@ func newPolicyId() string {
 }
 
 func (p *Policy) RegisterRemoteScript(scriptId string, scriptURL string, st *StackTrace) *TrustGroup {
+	dynamic := st != nil
+
 	if st != nil {
 		for _, cf := range st.CallFrames {
 			tg := p.TrustGroupForScriptId(cf.ScriptId)
 			if tg != nil && !tg.Trusted {
-				tg.AddRemoteScript(scriptId, scriptURL)
+				tg.AddRemoteScript(scriptId, scriptURL, dynamic)
 				return tg
 			}
 		}
@@ -55,7 +65,7 @@ func (p *Policy) RegisterRemoteScript(scriptId string, scriptURL string, st *Sta
 
 	for _, tg := range p.trustGroups {
 		if tg.ContainsRemoteScript(scriptId, scriptURL) {
-			tg.AddRemoteScript(scriptId, scriptURL)
+			tg.AddRemoteScript(scriptId, scriptURL, dynamic)
 			return tg
 		}
 	}
@@ -153,6 +163,47 @@ func (p *Policy) State() *PolicyState {
 	return ret
 }
 
+// GenerateCSP synthesizes a script-src directive from the scripts observed in
+// the TrustedGroup: each distinct origin of its RemoteScripts and a
+// 'sha256-<hash>' token for each InlineScripts entry. 'strict-dynamic' is
+// added if any trusted remote script was itself inserted by another script,
+// since a strict origin allowlist wouldn't cover scripts loaded that way.
+func (p *Policy) GenerateCSP() string {
+	tg := p.TrustedGroup()
+	if tg == nil {
+		return "script-src 'none'"
+	}
+
+	originSet := map[string]struct{}{}
+	strictDynamic := false
+	for _, rs := range tg.RemoteScripts() {
+		u, err := url.Parse(rs.URL)
+		if err != nil {
+			continue
+		}
+		originSet[fmt.Sprintf("%v://%v", u.Scheme, u.Host)] = struct{}{}
+		if rs.Dynamic {
+			strictDynamic = true
+		}
+	}
+
+	sources := []string{}
+	for origin := range originSet {
+		sources = append(sources, origin)
+	}
+	sort.Strings(sources)
+
+	for _, is := range tg.InlineScripts() {
+		sources = append(sources, fmt.Sprintf("'sha256-%v'", is.Hash))
+	}
+
+	if strictDynamic {
+		sources = append(sources, "'strict-dynamic'")
+	}
+
+	return fmt.Sprintf("script-src %v", strings.Join(sources, " "))
+}
+
 func NewTrustingPolicy() *Policy {
 	return &Policy{
 		Id: newPolicyId(),
@@ -177,12 +228,12 @@ func NewUntrustingPolicy() *Policy {
 	}
 }
 
-func NewHostnamePolicy(hosts []string) *Policy {
+func NewHostnamePolicy(hosts []string, matchMode int) *Policy {
 	return &Policy{
 		Id: newPolicyId(),
 		trustGroups: []*TrustGroup{
 			&TrustGroup{
-				ScriptSet: NewHostnameScriptSet(hosts),
+				ScriptSet: NewHostnameScriptSet(hosts, matchMode),
 				Trusted:   true,
 			},
 			&TrustGroup{
@@ -193,12 +244,12 @@ func NewHostnamePolicy(hosts []string) *Policy {
 	}
 }
 
-func NewFilterListPolicy() *Policy {
+func NewFilterListPolicy(pageOrigin string) *Policy {
 	return &Policy{
 		Id: newPolicyId(),
 		trustGroups: []*TrustGroup{
 			&TrustGroup{
-				ScriptSet: NewFilterListScriptSet(),
+				ScriptSet: NewFilterListScriptSet(pageOrigin),
 				Trusted:   false,
 			},
 			&TrustGroup{
@@ -209,6 +260,42 @@ func NewFilterListPolicy() *Policy {
 	}
 }
 
+// NewLearnedPolicy builds a policy for origin from whatever PolicyStore has
+// learned about it so far. On an origin's first visit (no seed) it behaves
+// like NewUntrustingPolicy while recording everything it sees; on later
+// visits the previously learned scripts seed a trusted allowlist and
+// anything else is untrusted.
+func NewLearnedPolicy(origin string, store *PolicyStore) *Policy {
+	seed := store.Seed(origin)
+	if seed == nil {
+		return &Policy{
+			Id:            newPolicyId(),
+			LearnedOrigin: origin,
+			trustGroups: []*TrustGroup{
+				&TrustGroup{
+					ScriptSet: &UniversalScriptSet{},
+					Trusted:   false,
+				},
+			},
+		}
+	}
+
+	return &Policy{
+		Id:            newPolicyId(),
+		LearnedOrigin: origin,
+		trustGroups: []*TrustGroup{
+			&TrustGroup{
+				ScriptSet: NewLearnedScriptSet(seed),
+				Trusted:   true,
+			},
+			&TrustGroup{
+				ScriptSet: &UniversalScriptSet{},
+				Trusted:   false,
+			},
+		},
+	}
+}
+
 // The Chrome Remote Debugger API doesn't currently give us a way to correlate
 // requestIds and interceptionIds, so we match them up using as many details of
 // the request as are available. In practice, this works well enough.
@@ -226,11 +313,25 @@ type Target struct {
 	NavHistory              []string
 	Policy                  *Policy
 	instrumentationScriptId string
+	webSockets              map[string]*webSocketInfo
+	// pendingInitiators correlates Network.requestWillBeSent's requestId
+	// (which Fetch.requestPaused reports back as networkId) to the
+	// initiator stack trace, since Fetch.requestPaused itself doesn't carry
+	// one.
+	pendingInitiators map[string]*Initiator
+}
+
+// webSocketInfo carries the context captured at Network.webSocketCreated
+// forward to the Network.webSocketFrameSent handler, which only receives a
+// requestId.
+type webSocketInfo struct {
+	URL       string
+	Initiator *Initiator
 }
 
 func NewTarget(cc *ChromeClient, targetId string, url string) *Target {
 	log.Println("NewTarget:", targetId)
-	c, err := godet.Connect(fmt.Sprintf("localhost:%v", remoteDebuggingPort), verbose)
+	c, err := godet.Connect(fmt.Sprintf("localhost:%v", cc.remoteDebuggingPort), verbose)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -240,31 +341,52 @@ func NewTarget(cc *ChromeClient, targetId string, url string) *Target {
 	})
 
 	t := &Target{
-		RemoteDebugger: c,
-		TargetId:       targetId,
-		chromeClient:   cc,
+		RemoteDebugger:    c,
+		TargetId:          targetId,
+		chromeClient:      cc,
+		webSockets:        make(map[string]*webSocketInfo),
+		pendingInitiators: make(map[string]*Initiator),
 	}
 	t.navReset(url)
 
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	_, err = t.SendRequest("Network.setRequestInterception", godet.Params{
-		"patterns": []godet.Params{{
-			"urlPattern": "http*",
-		}},
-	})
-	if err != nil {
-		log.Fatal(err)
+	cbm := map[string]func(Message){
+		"Debugger.scriptParsed":                     t.debuggerScriptParsed,
+		"Debugger.paused":                           t.debuggerPaused,
+		"Network.requestWillBeSent":                 t.networkRequestWillBeSent,
+		"Network.responseReceived":                  t.networkResponseReceived,
+		"Network.loadingFinished":                   t.networkLoadingFinished,
+		"Network.webSocketCreated":                  t.networkWebSocketCreated,
+		"Network.webSocketWillSendHandshakeRequest": t.networkWebSocketWillSendHandshakeRequest,
+		"Network.webSocketFrameSent":                t.networkWebSocketFrameSent,
+		"Runtime.consoleAPICalled":                  t.runtimeConsoleAPICalled,
+		"Page.lifecycleEvent":                       t.chromeClient.pageLifecycleEvent,
+		"Page.frameAttached":                        t.pageFrameAttached,
+		"Page.frameNavigated":                       t.pageFrameNavigated,
 	}
 
-	cbm := map[string]func(Message){
-		"Debugger.scriptParsed":      t.debuggerScriptParsed,
-		"Debugger.paused":            t.debuggerPaused,
-		"Network.requestIntercepted": t.networkRequestIntercepted,
-		"Network.requestWillBeSent":  t.networkRequestWillBeSent,
-		"Runtime.consoleAPICalled":   t.runtimeConsoleAPICalled,
-		"Page.lifecycleEvent":        t.chromeClient.pageLifecycleEvent,
+	if enforceMode {
+		_, err = t.SendRequest("Fetch.enable", godet.Params{
+			"patterns": []godet.Params{{
+				"urlPattern": "*",
+			}},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		cbm["Fetch.requestPaused"] = t.fetchRequestPaused
+	} else {
+		_, err = t.SendRequest("Network.setRequestInterception", godet.Params{
+			"patterns": []godet.Params{{
+				"urlPattern": "http*",
+			}},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		cbm["Network.requestIntercepted"] = t.networkRequestIntercepted
 	}
 
 	for method, cb := range cbm {
@@ -294,6 +416,13 @@ func (t *Target) initTarget() {
 		log.Panic(err)
 	}
 
+	// DOM.enable is required to read an iframe's sandbox attribute in
+	// pageFrameAttached below.
+	_, err = t.SendRequest("DOM.enable", godet.Params{})
+	if err != nil {
+		log.Panic(err)
+	}
+
 	t.installInstrumentation()
 }
 
@@ -312,9 +441,21 @@ func (t *Target) navReset(pageURL string) {
 			log.Panic(err)
 		}
 		origin := fmt.Sprintf("%v://%v", u.Scheme, u.Host)
-		t.Policy = NewHostnamePolicy([]string{origin})
+		t.Policy = NewHostnamePolicy([]string{origin}, hostnameMatchMode)
 	case policyTypeFilterList:
-		t.Policy = NewFilterListPolicy()
+		u, err := url.Parse(pageURL)
+		if err != nil {
+			log.Panic(err)
+		}
+		origin := fmt.Sprintf("%v://%v", u.Scheme, u.Host)
+		t.Policy = NewFilterListPolicy(origin)
+	case policyTypeLearned:
+		u, err := url.Parse(pageURL)
+		if err != nil {
+			log.Panic(err)
+		}
+		origin := fmt.Sprintf("%v://%v", u.Scheme, u.Host)
+		t.Policy = NewLearnedPolicy(origin, t.chromeClient.policyStore)
 	default:
 		log.Panic("bad policyType: this should have been checked in init()")
 	}
@@ -322,6 +463,23 @@ func (t *Target) navReset(pageURL string) {
 	t.chromeClient.policyIds = append(t.chromeClient.policyIds, t.Policy.Id)
 }
 
+// NavigateTo reuses this target's existing CDP connection to load pageURL,
+// so a batch driver can cycle one Chromium tab across a queue of URLs
+// instead of tearing down and relaunching a process per URL.
+func (t *Target) NavigateTo(pageURL string) error {
+	t.mutex.Lock()
+	t.webSockets = make(map[string]*webSocketInfo)
+	t.pendingInitiators = make(map[string]*Initiator)
+	t.mutex.Unlock()
+
+	t.navReset(pageURL)
+
+	_, err := t.SendRequest("Page.navigate", godet.Params{
+		"url": pageURL,
+	})
+	return err
+}
+
 func (t *Target) installInstrumentation() {
 	if t.instrumentationScriptId == "" {
 		r, err := t.SendRequest("Runtime.compileScript", godet.Params{
@@ -348,6 +506,75 @@ func (t *Target) installInstrumentation() {
 	}
 }
 
+// sriCheckScript re-fetches and re-hashes every <script src> with a declared
+// integrity attribute, the same check a browser's native SRI enforcement
+// performs, and returns a JSON array of {url, integrity} for every one whose
+// body no longer matches.
+const sriCheckScript = `
+(async () => {
+	const algoNames = {sha256: "SHA-256", sha384: "SHA-384", sha512: "SHA-512"};
+	const mismatches = [];
+	for (const el of document.querySelectorAll("script[src][integrity]")) {
+		const integrity = el.getAttribute("integrity");
+		const [algo, expected] = integrity.split("-");
+		try {
+			const resp = await fetch(el.src);
+			const buf = await resp.arrayBuffer();
+			const digest = await crypto.subtle.digest(algoNames[algo], buf);
+			const actual = btoa(String.fromCharCode(...new Uint8Array(digest)));
+			if (actual !== expected) {
+				mismatches.push({url: el.src, integrity: integrity});
+			}
+		} catch (e) {
+			mismatches.push({url: el.src, integrity: integrity});
+		}
+	}
+	return JSON.stringify(mismatches);
+})()
+`
+
+// checkSubresourceIntegrity runs sriCheckScript in-page and logs an
+// IntegrityMismatchEvent for each script whose fetched body doesn't match
+// its declared integrity attribute, so AnalyzePolicy can treat it as an
+// automatic policy violation (see analysis.go).
+func (t *Target) checkSubresourceIntegrity() {
+	r, err := t.SendRequest("Runtime.evaluate", godet.Params{
+		"expression":    sriCheckScript,
+		"awaitPromise":  true,
+		"returnByValue": true,
+	})
+	if err != nil {
+		log.Println("SRI check failed:", err)
+		return
+	}
+
+	raw := Message(r).Message("result").String("value")
+	if raw == "" {
+		return
+	}
+
+	var mismatches []struct {
+		URL       string `json:"url"`
+		Integrity string `json:"integrity"`
+	}
+	if err := json.Unmarshal([]byte(raw), &mismatches); err != nil {
+		log.Println("SRI check: bad result:", err)
+		return
+	}
+
+	for _, m := range mismatches {
+		t.chromeClient.LogEvent(&IntegrityMismatchEvent{
+			Timestamp:   time.Now(),
+			EventType:   "IntegrityMismatch",
+			PolicyId:    t.Policy.Id,
+			TargetId:    t.TargetId,
+			URL:         m.URL,
+			Integrity:   m.Integrity,
+			PolicyState: t.Policy.State(),
+		})
+	}
+}
+
 func (t *Target) PauseDebugger() {
 	return
 	_, err := t.SendRequest("Debugger.pause", nil)
@@ -400,7 +627,65 @@ func (t *Target) networkRequestIntercepted(p Message) {
 	})
 }
 
+// pageFrameAttached records a child frame's place in the frame tree as soon
+// as it's created, along with any sandbox="..." tokens on its owning
+// <iframe>, so AnalyzePolicy can consult them independent of taint state.
+func (t *Target) pageFrameAttached(p Message) {
+	frameId := p.String("frameId")
+
+	node := &FrameNode{
+		FrameId:       frameId,
+		ParentFrameId: p.String("parentFrameId"),
+		TargetId:      t.TargetId,
+	}
+
+	r, err := t.SendRequest("DOM.getFrameOwner", godet.Params{"frameId": frameId})
+	if err != nil {
+		log.Println("DOM.getFrameOwner failed:", err)
+		t.chromeClient.setFrameNode(node)
+		return
+	}
+
+	backendNodeId := Message(r).Int("backendNodeId")
+	dr, err := t.SendRequest("DOM.describeNode", godet.Params{"backendNodeId": backendNodeId})
+	if err != nil {
+		log.Println("DOM.describeNode failed:", err)
+		t.chromeClient.setFrameNode(node)
+		return
+	}
+
+	attrs := Message(dr).Message("node").Strings("attributes")
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i] == "sandbox" {
+			node.Sandboxed = true
+			node.SandboxFlags = parseSandboxAttr(attrs[i+1])
+		}
+	}
+
+	t.chromeClient.setFrameNode(node)
+}
+
+// pageFrameNavigated ensures every navigated frame has a FrameNode, mainly
+// to cover the top-level frame, which never fires Page.frameAttached.
+func (t *Target) pageFrameNavigated(p Message) {
+	frame := p.Message("frame")
+	frameId := frame.String("id")
+
+	if t.chromeClient.FrameNode(frameId) != nil {
+		return
+	}
+
+	t.chromeClient.setFrameNode(&FrameNode{
+		FrameId:       frameId,
+		ParentFrameId: frame.String("parentId"),
+		TargetId:      t.TargetId,
+	})
+}
+
 func (t *Target) networkRequestWillBeSent(p Message) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	initiator := NewInitiator(p.Message("initiator"))
 	scriptIds := []string{}
 	if initiator != nil && initiator.StackTrace != nil {
@@ -416,11 +701,14 @@ func (t *Target) networkRequestWillBeSent(p Message) {
 	reqURL := req.String("url")
 	log.Println("Request:", reqURL, scriptIds)
 
+	t.pendingInitiators[p.String("requestId")] = initiator
+
 	t.chromeClient.LogEvent(&NetworkRequestWillBeSentEvent{
 		Timestamp:      time.Unix(int64(p.Int("wallTime")), 0),
 		EventType:      "NetworkRequestWillBeSent",
 		PolicyId:       t.Policy.Id,
 		TargetId:       t.TargetId,
+		RequestId:      p.String("requestId"),
 		Initiator:      initiator,
 		LoaderId:       p.String("loaderId"),
 		FrameId:        p.String("frameId"),
@@ -432,6 +720,161 @@ func (t *Target) networkRequestWillBeSent(p Message) {
 	})
 }
 
+func (t *Target) networkResponseReceived(p Message) {
+	resp := p.Message("response")
+
+	t.chromeClient.LogEvent(&NetworkResponseReceivedEvent{
+		Timestamp:    time.Unix(int64(p.Int("timestamp")), 0),
+		EventType:    "NetworkResponseReceived",
+		PolicyId:     t.Policy.Id,
+		TargetId:     t.TargetId,
+		RequestId:    p.String("requestId"),
+		LoaderId:     p.String("loaderId"),
+		FrameId:      p.String("frameId"),
+		ResourceType: p.String("type"),
+		Response:     NewNetworkResponse(resp),
+		PolicyState:  t.Policy.State(),
+	})
+}
+
+func (t *Target) networkLoadingFinished(p Message) {
+	t.chromeClient.LogEvent(&LoadingFinishedEvent{
+		Timestamp:         time.Unix(int64(p.Int("timestamp")), 0),
+		EventType:         "LoadingFinished",
+		PolicyId:          t.Policy.Id,
+		TargetId:          t.TargetId,
+		RequestId:         p.String("requestId"),
+		EncodedDataLength: float64(p.Int("encodedDataLength")),
+	})
+}
+
+func (t *Target) fetchRequestPaused(p Message) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	requestId := p.String("requestId")
+	req := p.Message("request")
+	reqURL := req.String("url")
+	resourceType := p.String("resourceType")
+
+	scriptIds := []string{}
+	if initiator := t.pendingInitiators[p.String("networkId")]; initiator != nil && initiator.StackTrace != nil {
+		callFrames := initiator.StackTrace.CallFrames
+		if len(callFrames) == 0 && initiator.StackTrace.Parent != nil {
+			callFrames = initiator.StackTrace.Parent.CallFrames
+		}
+		for _, cf := range callFrames {
+			scriptIds = append(scriptIds, cf.ScriptId)
+		}
+	}
+
+	policyState := t.Policy.State()
+	if policyState.StackIsTrusted(scriptIds) {
+		_, err := t.SendRequest("Fetch.continueRequest", godet.Params{
+			"requestId": requestId,
+		})
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	log.Printf("Blocking [%v]: %v", resourceType, reqURL)
+
+	reason := "BlockedByClient"
+	switch resourceType {
+	case "Image", "Media", "Font":
+		// Failing these outright tends to show up as a broken-image icon or
+		// a console error that a site's own error handling may act on;
+		// fulfilling with an empty body is a quieter no-op instead.
+		_, err := t.SendRequest("Fetch.fulfillRequest", godet.Params{
+			"requestId":    requestId,
+			"responseCode": 200,
+			"body":         "",
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	default:
+		_, err := t.SendRequest("Fetch.failRequest", godet.Params{
+			"requestId":   requestId,
+			"errorReason": reason,
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	t.chromeClient.LogEvent(&NetworkRequestBlockedEvent{
+		Timestamp:      time.Now(),
+		EventType:      "NetworkRequestBlocked",
+		PolicyId:       t.Policy.Id,
+		TargetId:       t.TargetId,
+		RequestId:      requestId,
+		URL:            reqURL,
+		ResourceType:   resourceType,
+		Reason:         reason,
+		MatchedPattern: "*",
+		ScriptIdStack:  scriptIds,
+		PolicyState:    policyState,
+	})
+	t.chromeClient.metrics.requestsBlocked.WithLabelValues(reason, t.Policy.Id).Inc()
+}
+
+// WebRTC data channels are a second exfiltration channel alongside
+// WebSockets, but CDP has no native domain equivalent to
+// Network.webSocketCreated/webSocketFrameSent for them. Visibility comes
+// from the same shim_ mechanism cookie/localStorage/eval use instead: the
+// RTCPeerConnection.createDataChannel and RTCDataChannel.send entries in
+// js/shims.txt route both through debuggerPaused's APIAccessEvent path
+// below, the same as any other shimmed sink. directiveForAPIName's
+// connect-src bucket (csp_policy.go) already recognizes "rtcdatachannel"
+// in an APIName, which RTCDataChannel.send's shim produces.
+func (t *Target) networkWebSocketCreated(p Message) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	requestId := p.String("requestId")
+	t.webSockets[requestId] = &webSocketInfo{
+		URL:       p.String("url"),
+		Initiator: NewInitiator(p.Message("initiator")),
+	}
+}
+
+func (t *Target) networkWebSocketWillSendHandshakeRequest(p Message) {
+	// The handshake request doesn't carry any attribution beyond what
+	// webSocketCreated already gave us, so there's nothing to log here yet.
+	// It's still useful to subscribe to so the domain event ordering stays
+	// predictable for future handshake-level policy checks.
+}
+
+func (t *Target) networkWebSocketFrameSent(p Message) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	requestId := p.String("requestId")
+	info := t.webSockets[requestId]
+	if info == nil {
+		info = &webSocketInfo{}
+	}
+
+	response := p.Message("response")
+	log.Printf("WebSocket frame sent [%v]: %v", requestId, info.URL)
+
+	t.chromeClient.LogEvent(&WebSocketFrameSentEvent{
+		Timestamp:   time.Now(),
+		EventType:   "WebSocketFrameSent",
+		PolicyId:    t.Policy.Id,
+		TargetId:    t.TargetId,
+		RequestId:   requestId,
+		URL:         info.URL,
+		Initiator:   info.Initiator,
+		Opcode:      response.Int("opcode"),
+		PayloadData: response.String("payloadData"),
+		PolicyState: t.Policy.State(),
+	})
+}
+
 func (t *Target) runtimeConsoleAPICalled(p Message) {
 	if chromiumLog {
 		return
@@ -536,15 +979,37 @@ func (t *Target) debuggerPaused(p Message) {
 		}
 
 		if strings.HasPrefix(reasonStr, "shim_") {
+			// "." isn't a legal character in a JS identifier, so
+			// instrumentation_template.js names each shim function
+			// shim_<apiName> with "." replaced by "__" (e.g. shim_
+			// HTMLScriptElement__src for the "HTMLScriptElement.src"
+			// entry in js/shims.txt); undo that here to recover the
+			// APIName sinkAPINames/sourceAPINames/directiveForAPIName
+			// actually key on.
+			apiName := strings.ReplaceAll(strings.TrimPrefix(reasonStr, "shim_"), "__", ".")
+			trusted := t.Policy.State().StackIsTrusted(scriptIds)
+
+			// Capture the actual string value flowing through this shim,
+			// not just that it fired: for a sink (eval, innerHTML, ...)
+			// that's its first argument; for a getter-style source
+			// (cookie, localStorage, location), which take no arguments at
+			// all, it's the value the original getter is about to return.
+			// findSinkViolations (sinks.go) needs the real source value to
+			// check whether it actually shows up in a later sink's
+			// argument, not just that some source access happened nearby.
+			argument := t.evaluateShimValue(callFrames[0].String("callFrameId"))
+
 			t.chromeClient.LogEvent(&APIAccessEvent{
 				Timestamp:     time.Now(),
 				EventType:     "APIAccess",
 				PolicyId:      t.Policy.Id,
 				TargetId:      t.TargetId,
-				APIName:       strings.TrimPrefix(reasonStr, "shim_"),
+				APIName:       apiName,
+				Argument:      argument,
 				ScriptIdStack: scriptIds,
 				PolicyState:   t.Policy.State(),
 			})
+			t.chromeClient.metrics.apiAccessTotal.WithLabelValues(apiName, strconv.FormatBool(trusted)).Inc()
 		}
 	} else {
 		reasonStr = "breakpoint"
@@ -558,6 +1023,31 @@ func (t *Target) debuggerPaused(p Message) {
 	}
 }
 
+// evaluateShimValue reads back the string value flowing through a shim_
+// breakpoint, for use as APIAccessEvent.Argument: for a method/setter shim
+// (eval, innerHTML, document.write, ...) that's `arguments[0]`, a standard
+// binding inside the paused function's own scope; for a getter-style
+// source shim (cookie, localStorage, location), which is called with no
+// arguments at all, it's whatever installShim's own `origGet` closure
+// variable is about to return. Debugger.evaluateOnCallFrame resolves free
+// variables like `origGet` against the paused frame's full scope chain
+// (same as pasting the expression in at that point), so this one
+// expression covers both shapes without needing to know which kind of
+// shim paused here.
+func (t *Target) evaluateShimValue(callFrameId string) string {
+	r, err := t.SendRequest("Debugger.evaluateOnCallFrame", godet.Params{
+		"callFrameId": callFrameId,
+		"expression": "(typeof arguments !== 'undefined' && arguments.length > 0) ? String(arguments[0]) : " +
+			"(typeof origGet !== 'undefined' ? String(origGet.call(this)) : '')",
+		"returnByValue": true,
+	})
+	if err != nil {
+		log.Println("Failed to evaluate shim value:", err)
+		return ""
+	}
+	return Message(r).Message("result").String("value")
+}
+
 type JavaScriptShim struct {
 	Name     string
 	Object   string
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+// LearnedOrigin is the persisted allowlist learned for a single origin: the
+// remote script URLs and inline script hashes that were seen there on past
+// visits and should seed the trusted group on the next one.
+type LearnedOrigin struct {
+	RemoteScriptURLs []string
+	InlineHashes     []string
+}
+
+// PolicyStore persists LearnedOrigin snapshots, keyed by origin, to a JSON
+// file so a policyTypeLearned policy can build up trust across separate
+// runs instead of starting from scratch on every crawl.
+type PolicyStore struct {
+	sync.Mutex
+	path    string
+	origins map[string]*LearnedOrigin
+}
+
+func NewPolicyStore(path string) *PolicyStore {
+	ps := &PolicyStore{
+		path:    path,
+		origins: make(map[string]*LearnedOrigin),
+	}
+	ps.load()
+	return ps
+}
+
+func (ps *PolicyStore) load() {
+	b, err := ioutil.ReadFile(ps.path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if err := json.Unmarshal(b, &ps.origins); err != nil {
+		log.Panic(err)
+	}
+}
+
+func (ps *PolicyStore) save() {
+	b, err := json.MarshalIndent(ps.origins, "", "  ")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if err := ioutil.WriteFile(ps.path, b, 0644); err != nil {
+		log.Panic(err)
+	}
+}
+
+// Seed returns the previously learned allowlist for origin, or nil if
+// origin has never been committed.
+func (ps *PolicyStore) Seed(origin string) *LearnedOrigin {
+	ps.Lock()
+	defer ps.Unlock()
+
+	return ps.origins[origin]
+}
+
+// Commit merges everything state saw for origin into the existing learned
+// allowlist and persists the result, so the next visit to origin trusts it.
+func (ps *PolicyStore) Commit(origin string, state *PolicyState) {
+	ps.Lock()
+	defer ps.Unlock()
+
+	lo := ps.origins[origin]
+	if lo == nil {
+		lo = &LearnedOrigin{}
+		ps.origins[origin] = lo
+	}
+
+	seenURLs := make(map[string]struct{})
+	for _, u := range lo.RemoteScriptURLs {
+		seenURLs[u] = struct{}{}
+	}
+	seenHashes := make(map[string]struct{})
+	for _, h := range lo.InlineHashes {
+		seenHashes[h] = struct{}{}
+	}
+
+	for _, tg := range state.TrustGroups {
+		for _, rs := range tg.RemoteScripts {
+			if _, ok := seenURLs[rs.URL]; ok {
+				continue
+			}
+			seenURLs[rs.URL] = struct{}{}
+			lo.RemoteScriptURLs = append(lo.RemoteScriptURLs, rs.URL)
+		}
+		for _, is := range tg.InlineScripts {
+			if _, ok := seenHashes[is.Hash]; ok {
+				continue
+			}
+			seenHashes[is.Hash] = struct{}{}
+			lo.InlineHashes = append(lo.InlineHashes, is.Hash)
+		}
+	}
+
+	ps.save()
+}
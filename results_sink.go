@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// ResultsSink uploads a completed run's logs somewhere durable. Key is a
+// sink-relative path (normally runDir/namespace) that implementations use
+// to namespace the upload; it does not need to be a filesystem path on the
+// destination.
+type ResultsSink interface {
+	// UploadFile uploads the single file at localPath under key.
+	UploadFile(localPath string, key string) error
+	// UploadDir tars runDir and uploads the tarball under key.
+	UploadDir(runDir string, key string) error
+}
+
+// newResultsSink parses rawURL (e.g. "s3://bucket/prefix", "gs://bucket",
+// "azblob://account/container/prefix", "https://host/path",
+// "file:///var/results") and returns the matching ResultsSink. An empty
+// rawURL returns nil, meaning uploads are disabled.
+func newResultsSink(awsSess *session.Session, rawURL string) ResultsSink {
+	if rawURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		log.Fatal("bad -results-sink URL:", err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return &fileResultsSink{baseDir: path.Join(u.Host, u.Path)}
+	case "s3":
+		return &s3ResultsSink{awsSess: awsSess, bucket: u.Host, prefix: strings.TrimPrefix(u.Path, "/")}
+	case "gs":
+		return &gcsResultsSink{bucket: u.Host, prefix: strings.TrimPrefix(u.Path, "/")}
+	case "azblob":
+		container, prefix := splitAzureContainerPath(u.Path)
+		return &azureResultsSink{account: u.Host, container: container, prefix: prefix}
+	case "http", "https":
+		return &httpResultsSink{endpoint: rawURL}
+	default:
+		log.Fatalf("bad -results-sink URL: unsupported scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// splitAzureContainerPath splits the path component of an azblob:// URL
+// ("/container/some/prefix") into its container and prefix parts.
+func splitAzureContainerPath(p string) (container string, prefix string) {
+	p = strings.TrimPrefix(p, "/")
+	parts := strings.SplitN(p, "/", 2)
+	container = parts[0]
+	if len(parts) == 2 {
+		prefix = parts[1]
+	}
+	return container, prefix
+}
+
+// withRetry calls f up to 5 times, backing off exponentially (1s, 2s, 4s,
+// 8s) between attempts, so a transient network blip during a long
+// batch crawl doesn't drop a run's results.
+func withRetry(what string, f func() error) error {
+	var err error
+	backoff := time.Second
+	for attempt := 0; attempt < 5; attempt++ {
+		if attempt > 0 {
+			log.Printf("%v failed, retrying in %v: %v", what, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = f(); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: giving up after retries: %w", what, err)
+}
+
+// fileResultsSink copies results into a local directory, for runs that
+// keep their own results rather than shipping them to a remote store.
+type fileResultsSink struct {
+	baseDir string
+}
+
+func (s *fileResultsSink) UploadFile(localPath string, key string) error {
+	dst := path.Join(s.baseDir, key)
+	if err := os.MkdirAll(path.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return copyFile(localPath, dst)
+}
+
+func (s *fileResultsSink) UploadDir(runDir string, key string) error {
+	tarFileName, err := tarDir(runDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tarFileName)
+	return s.UploadFile(tarFileName, key+".tar.gz")
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// s3ResultsSink uploads to S3 via s3manager, which already splits large
+// bodies into multipart uploads and resumes failed parts on its own; the
+// withRetry wrapper covers the surrounding request (bucket unreachable,
+// throttling) that multipart resume alone doesn't.
+type s3ResultsSink struct {
+	awsSess *session.Session
+	bucket  string
+	prefix  string
+}
+
+func (s *s3ResultsSink) UploadFile(localPath string, key string) error {
+	return withRetry("s3 upload", func() error {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		uploader := s3manager.NewUploader(s.awsSess)
+		_, err = uploader.Upload(&s3manager.UploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(path.Join(s.prefix, key)),
+			Body:   f,
+		})
+		return err
+	})
+}
+
+func (s *s3ResultsSink) UploadDir(runDir string, key string) error {
+	tarFileName, err := tarDir(runDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tarFileName)
+	return s.UploadFile(tarFileName, key+".tar.gz")
+}
+
+// gcsResultsSink uploads to Google Cloud Storage via the JSON API's simple
+// upload endpoint, authenticated with a bearer token from
+// GOOGLE_OAUTH_TOKEN. This tree doesn't vendor the GCS client library, so
+// this speaks the REST API directly rather than pulling in a new
+// dependency for one call shape.
+type gcsResultsSink struct {
+	bucket string
+	prefix string
+}
+
+func (s *gcsResultsSink) UploadFile(localPath string, key string) error {
+	return withRetry("gcs upload", func() error {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		objectName := path.Join(s.prefix, key)
+		endpoint := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%v/o?uploadType=media&name=%v",
+			s.bucket, url.QueryEscape(objectName))
+
+		req, err := http.NewRequest(http.MethodPost, endpoint, f)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if token := os.Getenv("GOOGLE_OAUTH_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("gcs upload: unexpected status %v", resp.Status)
+		}
+		return nil
+	})
+}
+
+func (s *gcsResultsSink) UploadDir(runDir string, key string) error {
+	tarFileName, err := tarDir(runDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tarFileName)
+	return s.UploadFile(tarFileName, key+".tar.gz")
+}
+
+// azureResultsSink uploads to an Azure Blob Storage container via the Put
+// Blob REST call, authenticated with a SAS token from AZURE_STORAGE_SAS_TOKEN.
+// Like gcsResultsSink, this tree doesn't vendor the Azure SDK, so this
+// speaks the REST API directly rather than pulling in a new dependency for
+// one call shape.
+type azureResultsSink struct {
+	account   string
+	container string
+	prefix    string
+}
+
+func (s *azureResultsSink) UploadFile(localPath string, key string) error {
+	return withRetry("azure blob upload", func() error {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		blobName := path.Join(s.prefix, key)
+		endpoint := fmt.Sprintf("https://%v.blob.core.windows.net/%v/%v", s.account, s.container, blobName)
+		if sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); sas != "" {
+			endpoint += "?" + strings.TrimPrefix(sas, "?")
+		}
+
+		req, err := http.NewRequest(http.MethodPut, endpoint, f)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = info.Size()
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		req.Header.Set("x-ms-version", "2020-04-08")
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("azure blob upload: unexpected status %v", resp.Status)
+		}
+		return nil
+	})
+}
+
+func (s *azureResultsSink) UploadDir(runDir string, key string) error {
+	tarFileName, err := tarDir(runDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tarFileName)
+	return s.UploadFile(tarFileName, key+".tar.gz")
+}
+
+// httpResultsSink POSTs results to an arbitrary HTTP endpoint as a
+// multipart/form-data "file" field, for teams that front their own
+// results store with a simple upload handler.
+type httpResultsSink struct {
+	endpoint string
+}
+
+func (s *httpResultsSink) UploadFile(localPath string, key string) error {
+	return withRetry("http upload", func() error {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		body := &bytes.Buffer{}
+		w := multipart.NewWriter(body)
+		part, err := w.CreateFormFile("file", key)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, f); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, s.endpoint, body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", w.FormDataContentType())
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("http upload: unexpected status %v", resp.Status)
+		}
+		return nil
+	})
+}
+
+func (s *httpResultsSink) UploadDir(runDir string, key string) error {
+	tarFileName, err := tarDir(runDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tarFileName)
+	return s.UploadFile(tarFileName, key+".tar.gz")
+}
+
+// resultsSink is the destination configured by -results-sink (or, for
+// backward compatibility, -upload-results/-s3-bucket); nil disables
+// uploads entirely. Set once in main() before any run starts.
+var resultsSink ResultsSink
+
+// resultsSinkStream controls whether uploadRunResults ships the run dir's
+// log files individually as soon as they exist, instead of waiting to tar
+// the whole run dir at the end. See uploadRunResults.
+var resultsSinkStream bool
+
+// uploadRunResults ships rd (a single run's output directory) to
+// resultsSink under namespace, or does nothing if no sink is configured.
+func uploadRunResults(rd string, namespace string) {
+	if resultsSink == nil {
+		return
+	}
+	if resultsSinkStream {
+		streamRunResults(rd, namespace)
+		return
+	}
+	if err := resultsSink.UploadDir(rd, namespace); err != nil {
+		log.Println("Upload failed:", err)
+	}
+}
+
+// streamRunResults uploads each file already written under rd/logs
+// individually, rather than tarring the whole run dir, so a long batch
+// crawl killed mid-run still has every URL it finished durably stored. It
+// also uploads rd/screenshot.png (written directly to rd by chrome_client.go,
+// not under rd/logs) when present, so a crashed run doesn't silently ship
+// without the one artifact most useful for triaging it.
+func streamRunResults(rd string, namespace string) {
+	entries, err := os.ReadDir(path.Join(rd, "logs"))
+	if err != nil {
+		log.Println("Stream upload: reading logs dir:", err)
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		local := path.Join(rd, "logs", e.Name())
+		key := path.Join(namespace, "logs", e.Name())
+		if err := resultsSink.UploadFile(local, key); err != nil {
+			log.Println("Stream upload failed for", local, ":", err)
+		}
+	}
+
+	screenshot := path.Join(rd, "screenshot.png")
+	if _, err := os.Stat(screenshot); err != nil {
+		return
+	}
+	if err := resultsSink.UploadFile(screenshot, path.Join(namespace, "screenshot.png")); err != nil {
+		log.Println("Stream upload failed for", screenshot, ":", err)
+	}
+}
+
+// tarDir compresses runDir into a temp tarball and returns its path.
+func tarDir(runDir string) (string, error) {
+	tarFileName := filepath.Join(os.TempDir(), fmt.Sprintf("%v.tar.gz", path.Base(runDir)))
+	cmd := exec.Command("tar", "-czf", tarFileName, runDir)
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return tarFileName, nil
+}
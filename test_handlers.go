@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha512"
+	"encoding/base64"
 	"log"
 	"net/http"
 	"text/template"
@@ -11,6 +13,7 @@ type TestHandler struct {
 	RemoteScripts         []string
 	InlineScripts         []string
 	HTMLBody              string
+	Integrity             map[string]string
 	ExpectTaintingAPIName string
 	ExpectPolicyViolated  bool
 	ExpectReqResourceType string
@@ -26,7 +29,12 @@ func (h TestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		<link rel="icon" href="data:,">
 		<title>{{.Path}}</title>
 		{{range .RemoteScripts}}
-			<script src="{{.}}"></script>
+			{{$integrity := index $.Integrity .}}
+			{{if $integrity}}
+				<script src="{{.}}" integrity="{{$integrity}}" crossorigin="anonymous"></script>
+			{{else}}
+				<script src="{{.}}"></script>
+			{{end}}
 		{{end}}
 		{{range .InlineScripts}}
 			<script>{{.}}</script>
@@ -241,6 +249,69 @@ var testHandlers = []TestHandler{
 		},
 	},
 
+	TestHandler{
+		Path: "/Policy1/TestSandboxedFormSubmit/Fail",
+		HTMLBody: `
+			<iframe sandbox="allow-scripts" srcdoc="
+				<form id='form' method='post' action='https://httpbin.org/post'></form>
+				<script>document.getElementById('form').submit();</script>
+			"></iframe>
+		`,
+	},
+
+	TestHandler{
+		Path: "/Policy1/TestSandboxedFormSubmit/Pass",
+		HTMLBody: `
+			<iframe sandbox="allow-scripts allow-forms" srcdoc="
+				<form id='form' method='post' action='https://httpbin.org/post'></form>
+				<script>document.getElementById('form').submit();</script>
+			"></iframe>
+		`,
+	},
+
+	// TestNestedSandboxedFormSubmitInner is the inner frame's content,
+	// referenced via src= (not srcdoc=) by the two fixtures below so its
+	// own <script> doesn't have to survive being escaped into an outer
+	// srcdoc attribute. It declares no sandbox of its own: per the HTML
+	// sandboxing model it still inherits whatever an ancestor <iframe
+	// sandbox="..."> restricts, which is exactly what these two fixtures
+	// check.
+	TestHandler{
+		Path: "/Policy1/TestNestedSandboxedFormSubmitInner",
+		HTMLBody: `
+			<form id='form' method='post' action='https://httpbin.org/post'></form>
+			<script>document.getElementById('form').submit();</script>
+		`,
+	},
+
+	TestHandler{
+		Path: "/Policy1/TestNestedSandboxedFormSubmit/Fail",
+		HTMLBody: `
+			<iframe sandbox="allow-scripts" src="/Policy1/TestNestedSandboxedFormSubmitInner"></iframe>
+		`,
+	},
+
+	TestHandler{
+		Path: "/Policy1/TestNestedSandboxedFormSubmit/Pass",
+		HTMLBody: `
+			<iframe sandbox="allow-scripts allow-forms" src="/Policy1/TestNestedSandboxedFormSubmitInner"></iframe>
+		`,
+	},
+
+	TestHandler{
+		Path: "/Policy1/TestSandboxedPopup/Fail",
+		HTMLBody: `
+			<iframe sandbox="allow-scripts" srcdoc="<script>window.open('https://httpbin.org/html');</script>"></iframe>
+		`,
+	},
+
+	TestHandler{
+		Path: "/Policy1/TestSandboxedPopup/Pass",
+		HTMLBody: `
+			<iframe sandbox="allow-scripts allow-popups" srcdoc="<script>window.open('https://httpbin.org/html');</script>"></iframe>
+		`,
+	},
+
 	TestHandler{
 		Path: "/Policy2/TestSameHostname/Pass",
 		RemoteScripts: []string{
@@ -299,4 +370,60 @@ var testHandlers = []TestHandler{
 			"https://eal-exfiltration.s3.amazonaws.com/test_script_4.js",
 		},
 	},
+
+	TestHandler{
+		Path:          "/Policy2/TestSRI/Pass",
+		RemoteScripts: []string{"/static/sri_test_script.js"},
+		Integrity: map[string]string{
+			"/static/sri_test_script.js": sriIntegrity(sriTestScriptBody),
+		},
+	},
+
+	TestHandler{
+		Path:          "/Policy2/TestSRI/Fail",
+		RemoteScripts: []string{"/static/sri_test_script_mutated.js"},
+		Integrity: map[string]string{
+			// Declares the digest of the original body, but the handler
+			// registered for this path in test_server.go serves a mutated
+			// copy, so the fetched bytes won't match.
+			"/static/sri_test_script_mutated.js": sriIntegrity(sriTestScriptBody),
+		},
+	},
+
+	TestHandler{
+		Path: "/Policy3/TestThirdPartyAnalytics/Fail",
+		RemoteScripts: []string{
+			// google-analytics.com/analytics.js is listed in EasyPrivacy, so
+			// NewFilterListScriptSet (trust_group.go) should distrust this
+			// script and AnalyzePolicy should flag whatever it does as a
+			// violation, same as an untrusted script under Policy1.
+			"https://www.google-analytics.com/analytics.js",
+		},
+	},
+
+	TestHandler{
+		Path: "/Policy3/TestOwnOriginScript/Pass",
+		RemoteScripts: []string{
+			"/static/test_script_1.js",
+		},
+	},
+}
+
+// sriTestScriptBody is the source served (verbatim or mutated) for the
+// /Policy2/TestSRI/* cases.
+const sriTestScriptBody = `console.log("sri test script ran");`
+
+// sriIntegrity returns the sha384-based Subresource Integrity value for
+// body, in the "sha384-<base64>" form a <script integrity="..."> expects.
+func sriIntegrity(body string) string {
+	sum := sha512.Sum384([]byte(body))
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// mutateByte flips the first byte of body, so a script served from the
+// result no longer matches a digest computed over the original.
+func mutateByte(body string) string {
+	b := []byte(body)
+	b[0] ^= 0xff
+	return string(b)
 }
@@ -9,6 +9,10 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func listFlags() map[string]flag.Value {
@@ -33,6 +37,8 @@ func activePolicyType(cc *ChromeClient) string {
 		return pre + "Trust hostname matching page origin"
 	case policyTypeFilterList:
 		return pre + "Distrust ad/analytics scripts"
+	case policyTypeLearned:
+		return pre + "Trust scripts learned from past visits"
 	default:
 		log.Panic("bad policyType: this should have been checked in init()")
 		return ""
@@ -51,6 +57,16 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, testServerURL(r.FormValue("path")), http.StatusFound)
 }
 
+// serveScript returns a handler that serves body as application/javascript,
+// for test scripts (see test_handlers.go's /Policy2/TestSRI/* cases) that
+// don't need a file on disk under static/.
+func serveScript(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Write([]byte(body))
+	}
+}
+
 type HomeHandler struct {
 	chromeClient *ChromeClient
 	template     *template.Template
@@ -134,6 +150,69 @@ func (h LogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// eventStreamHandler upgrades to text/event-stream and pushes each LogEvent
+// for ?policy=<id> as it's appended, so a run can be watched live instead
+// of only inspected post-mortem via /event_log. A Last-Event-ID header lets
+// a reconnecting client replay whatever it missed.
+func (cc *ChromeClient) eventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	policyId := r.URL.Query().Get("policy")
+
+	lastEventId := 0
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil {
+			lastEventId = n
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	sub, replay := cc.SubscribeFrom(policyId, lastEventId)
+	defer cc.Unsubscribe(sub)
+
+	for _, e := range replay {
+		writeSSEEvent(w, e)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-sub.ch:
+			writeSSEEvent(w, e)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSEEvent(w http.ResponseWriter, e interface{}) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Fprintf(w, "id: %d\ndata: %s\n\n", eventEventId(e), strings.ReplaceAll(string(b), "\n", "\ndata: "))
+}
+
+func (cc *ChromeClient) harHandler(w http.ResponseWriter, r *http.Request) {
+	har := cc.GenerateHAR(r.URL.Query().Get("policy"))
+
+	w.Header().Set("Content-Type", "application/json")
+	b, err := json.MarshalIndent(map[string]interface{}{"log": har}, "", "  ")
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Write(b)
+}
+
 func (cc *ChromeClient) initTestServer() *http.Server {
 	mux := http.NewServeMux()
 	fs := http.FileServer(http.Dir("static"))
@@ -144,15 +223,20 @@ func (cc *ChromeClient) initTestServer() *http.Server {
 		http.Redirect(w, r, "/", http.StatusFound)
 	})
 	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	mux.HandleFunc("/static/sri_test_script.js", serveScript(sriTestScriptBody))
+	mux.HandleFunc("/static/sri_test_script_mutated.js", serveScript(mutateByte(sriTestScriptBody)))
 	mux.HandleFunc("/redirect", redirectHandler)
 	mux.Handle("/event_log", NewLogsHandler(cc))
+	mux.HandleFunc("/event_log.har", cc.harHandler)
+	mux.HandleFunc("/event_stream", cc.eventStreamHandler)
+	mux.Handle("/metrics", promhttp.HandlerFor(cc.registry, promhttp.HandlerOpts{}))
 	mux.Handle("/", NewHomeHandler(cc))
 
 	for _, th := range testHandlers {
 		mux.Handle(th.Path, th)
 	}
 
-	srv := &http.Server{Addr: fmt.Sprintf(":%d", webServerPort), Handler: mux}
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", cc.webServerPort), Handler: mux}
 	listener, err := net.Listen("tcp", srv.Addr)
 	if err != nil {
 		log.Fatal(err)
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/pmezard/adblock/adblock"
+	"github.com/eal5ub/web-exfiltration/filterlist"
+	"golang.org/x/net/publicsuffix"
 )
 
 type TrustGroup struct {
@@ -19,6 +22,9 @@ type TrustGroup struct {
 type RemoteScript struct {
 	ScriptId string
 	URL      string
+	// Dynamic is true if the script was inserted by another script (it has
+	// an initiator stack trace) rather than being a static <script> tag.
+	Dynamic bool
 }
 
 type InlineScript struct {
@@ -27,7 +33,7 @@ type InlineScript struct {
 }
 
 type ScriptSet interface {
-	AddRemoteScript(scriptId string, scriptURL string)
+	AddRemoteScript(scriptId string, scriptURL string, dynamic bool)
 	AddInlineScript(scriptId string, hash string)
 	ContainsRemoteScript(scriptId string, scriptURL string) bool
 	ContainsInlineScript(scriptId string, hash string) bool
@@ -40,10 +46,11 @@ type UniversalScriptSet struct {
 	inlineScripts []*InlineScript
 }
 
-func (ss *UniversalScriptSet) AddRemoteScript(scriptId string, scriptURL string) {
+func (ss *UniversalScriptSet) AddRemoteScript(scriptId string, scriptURL string, dynamic bool) {
 	ss.remoteScripts = append(ss.remoteScripts, &RemoteScript{
 		ScriptId: scriptId,
 		URL:      scriptURL,
+		Dynamic:  dynamic,
 	})
 }
 
@@ -70,22 +77,32 @@ func (ss *UniversalScriptSet) InlineScripts() []*InlineScript {
 	return ss.inlineScripts
 }
 
+// Hostname match modes for HostnameScriptSet, from strictest to loosest.
+const (
+	hostnameMatchExact = iota
+	hostnameMatchSubdomain
+	hostnameMatchETLDPlusOne
+)
+
 type HostnameScriptSet struct {
 	hostnames     []string
+	matchMode     int
 	remoteScripts []*RemoteScript
 	inlineScripts []*InlineScript
 }
 
-func NewHostnameScriptSet(hostnames []string) *HostnameScriptSet {
+func NewHostnameScriptSet(hostnames []string, matchMode int) *HostnameScriptSet {
 	return &HostnameScriptSet{
 		hostnames: hostnames,
+		matchMode: matchMode,
 	}
 }
 
-func (ss *HostnameScriptSet) AddRemoteScript(scriptId string, scriptURL string) {
+func (ss *HostnameScriptSet) AddRemoteScript(scriptId string, scriptURL string, dynamic bool) {
 	ss.remoteScripts = append(ss.remoteScripts, &RemoteScript{
 		ScriptId: scriptId,
 		URL:      scriptURL,
+		Dynamic:  dynamic,
 	})
 }
 
@@ -103,9 +120,27 @@ func (ss *HostnameScriptSet) ContainsRemoteScript(scriptId string, scriptURL str
 	}
 
 	for _, h := range ss.hostnames {
-		origin := fmt.Sprintf("%v://%v", u.Scheme, u.Host)
-		if h == origin {
-			return true
+		seed, err := url.Parse(h)
+		if err != nil {
+			continue
+		}
+
+		switch ss.matchMode {
+		case hostnameMatchSubdomain:
+			if u.Host == seed.Host || strings.HasSuffix(u.Host, "."+seed.Host) {
+				return true
+			}
+		case hostnameMatchETLDPlusOne:
+			scriptDomain, err1 := publicsuffix.EffectiveTLDPlusOne(u.Hostname())
+			seedDomain, err2 := publicsuffix.EffectiveTLDPlusOne(seed.Hostname())
+			if err1 == nil && err2 == nil && scriptDomain == seedDomain {
+				return true
+			}
+		default:
+			origin := fmt.Sprintf("%v://%v", u.Scheme, u.Host)
+			if h == origin {
+				return true
+			}
 		}
 	}
 	return false
@@ -123,32 +158,112 @@ func (ss *HostnameScriptSet) InlineScripts() []*InlineScript {
 	return ss.inlineScripts
 }
 
-type FilterListScriptSet struct {
-	filters       []string
+// LearnedScriptSet trusts exactly the remote script URLs and inline script
+// hashes recorded for an origin in a PolicyStore on a previous visit.
+type LearnedScriptSet struct {
+	seed          *LearnedOrigin
 	remoteScripts []*RemoteScript
 	inlineScripts []*InlineScript
-	matcher       *adblock.RuleMatcher
 }
 
-var cachedMatcher *adblock.RuleMatcher
+func NewLearnedScriptSet(seed *LearnedOrigin) *LearnedScriptSet {
+	return &LearnedScriptSet{
+		seed: seed,
+	}
+}
 
-func NewFilterListScriptSet() *FilterListScriptSet {
-	if cachedMatcher == nil {
-		m, _, err := adblock.NewMatcherFromFiles("filter_lists/easylist.txt", "filter_lists/easyprivacy.txt")
-		if err != nil {
-			log.Panic(err)
+func (ss *LearnedScriptSet) AddRemoteScript(scriptId string, scriptURL string, dynamic bool) {
+	ss.remoteScripts = append(ss.remoteScripts, &RemoteScript{
+		ScriptId: scriptId,
+		URL:      scriptURL,
+		Dynamic:  dynamic,
+	})
+}
+
+func (ss *LearnedScriptSet) AddInlineScript(scriptId string, hash string) {
+	ss.inlineScripts = append(ss.inlineScripts, &InlineScript{
+		ScriptId: scriptId,
+		Hash:     hash,
+	})
+}
+
+func (ss *LearnedScriptSet) ContainsRemoteScript(scriptId string, scriptURL string) bool {
+	for _, u := range ss.seed.RemoteScriptURLs {
+		if u == scriptURL {
+			return true
 		}
-		cachedMatcher = m
 	}
+	return false
+}
+
+func (ss *LearnedScriptSet) ContainsInlineScript(scriptId string, hash string) bool {
+	for _, h := range ss.seed.InlineHashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func (ss *LearnedScriptSet) RemoteScripts() []*RemoteScript {
+	return ss.remoteScripts
+}
+
+func (ss *LearnedScriptSet) InlineScripts() []*InlineScript {
+	return ss.inlineScripts
+}
+
+// FilterListScriptSet distrusts remote scripts matched by a non-exception
+// network rule loaded from -filter-lists (filterlist.Matcher), with
+// third-partyness computed against pageOrigin. sharedFilterListMatcher is
+// loaded once per process and hot-reloaded on a timer by
+// refreshFilterListsPeriodically (below) so every FilterListScriptSet
+// (one per Target, see policy.go) sees updates without re-parsing the
+// lists itself.
+type FilterListScriptSet struct {
+	remoteScripts []*RemoteScript
+	inlineScripts []*InlineScript
+	pageOrigin    string
+}
+
+var sharedFilterListMatcher = filterlist.NewMatcher()
+var filterListLoadOnce sync.Once
+
+func NewFilterListScriptSet(pageOrigin string) *FilterListScriptSet {
+	filterListLoadOnce.Do(func() {
+		if err := sharedFilterListMatcher.Load(strings.Split(filterListsFlag, ",")); err != nil {
+			log.Fatal(err)
+		}
+		if filterListRefresh > 0 {
+			go refreshFilterListsPeriodically()
+		}
+	})
 	return &FilterListScriptSet{
-		matcher: cachedMatcher,
+		pageOrigin: pageOrigin,
 	}
 }
 
-func (ss *FilterListScriptSet) AddRemoteScript(scriptId string, scriptURL string) {
+// refreshFilterListsPeriodically reloads sharedFilterListMatcher from
+// -filter-lists every -filter-lists-refresh, so a long-running -control
+// mode process picks up list updates without a restart. Load errors (a
+// list temporarily missing mid-edit, say) are logged and skipped rather
+// than torn down, keeping whatever rule set was last loaded successfully.
+func refreshFilterListsPeriodically() {
+	t := time.NewTicker(filterListRefresh)
+	defer t.Stop()
+
+	for range t.C {
+		if err := sharedFilterListMatcher.Load(strings.Split(filterListsFlag, ",")); err != nil {
+			log.Println("filter list refresh failed:", err)
+		}
+	}
+}
+
+func (ss *FilterListScriptSet) AddRemoteScript(scriptId string, scriptURL string, dynamic bool) {
 	ss.remoteScripts = append(ss.remoteScripts, &RemoteScript{
 		ScriptId: scriptId,
 		URL:      scriptURL,
+		Dynamic:  dynamic,
 	})
 }
 
@@ -160,14 +275,11 @@ func (ss *FilterListScriptSet) AddInlineScript(scriptId string, hash string) {
 }
 
 func (ss *FilterListScriptSet) ContainsRemoteScript(scriptId string, scriptURL string) bool {
-	matched, _, err := ss.matcher.Match(&adblock.Request{
-		URL: scriptURL,
+	return sharedFilterListMatcher.Match(filterlist.Request{
+		URL:          scriptURL,
+		ResourceType: "script",
+		PageOrigin:   ss.pageOrigin,
 	})
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-	return matched
 }
 
 func (ss *FilterListScriptSet) ContainsInlineScript(scriptId string, hash string) bool {